@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Alphabet is a named set of chars a definition can use instead of Chars.
+type Alphabet struct {
+	Chars string
+
+	// Fold is true if aliases are matched ignoring case. Aliases are
+	// generated in upper case and input is upper-cased before matching.
+	Fold bool
+
+	// Replace maps lookalike chars that are not in the alphabet to the chars
+	// they are read as.
+	Replace *strings.Replacer
+}
+
+// Alphabets are the presets selectable by the alphabet of a definition.
+var Alphabets = map[string]*Alphabet{
+	// Crockford's base32, which excludes I, L, O, and U and reads I and L as
+	// 1 and O as 0.
+	"crockford": {
+		Chars:   crockford,
+		Fold:    true,
+		Replace: strings.NewReplacer("I", "1", "L", "1", "O", "0"),
+	},
+
+	"numeric": {
+		Chars: digitChars,
+	},
+
+	// Upper-case letters and digits without the lookalikes 0, 1, I, L, and O.
+	"unambiguous": {
+		Chars: "23456789ABCDEFGHJKMNPQRSTUVWXYZ",
+		Fold:  true,
+	},
+}
+
+// applyAlphabet sets the chars of the definition to those of its alphabet.
+func applyAlphabet(def *Def) error {
+	if def.Alphabet == "" {
+		return nil
+	}
+
+	a, ok := Alphabets[def.Alphabet]
+	if !ok {
+		return fmt.Errorf("unknown alphabet '%s'", def.Alphabet)
+	}
+
+	switch def.Type {
+	case "rand", "hmac", "hashid", "fpe", "pattern":
+	default:
+		return fmt.Errorf("alphabet is not supported by %s", def.Type)
+	}
+
+	def.Chars = a.Chars
+
+	return nil
+}
+
+// uniqueChars returns an error if a char is repeated, which would make it
+// more likely than the others.
+func uniqueChars(chars string) error {
+	for i := 0; i < len(chars); i++ {
+		if strings.IndexByte(chars, chars[i]) != i {
+			return fmt.Errorf("chars must be unique, '%c' is repeated", chars[i])
+		}
+	}
+
+	return nil
+}
+
+// validateChars checks the chars of the definition if its generator uses
+// them.
+func validateChars(def *Def) error {
+	switch def.Type {
+	case "rand", "hmac", "hashid", "fpe", "pattern":
+		return uniqueChars(def.Chars)
+	}
+
+	return nil
+}
+
+// normalizeAlias returns the alias as it is generated by the alphabet of the
+// definition. Aliases of alphabets that are not case-insensitive are returned
+// as is.
+func normalizeAlias(def *Def, alias string) string {
+	a, ok := Alphabets[def.Alphabet]
+	if !ok || !a.Fold {
+		return alias
+	}
+
+	prefix := def.aliasPrefix()
+
+	n := len(prefix)
+	if len(alias) < n || !strings.EqualFold(alias[:n], prefix) {
+		return alias
+	}
+
+	rest := strings.ToUpper(alias[n:])
+
+	if a.Replace != nil {
+		rest = a.Replace.Replace(rest)
+	}
+
+	return prefix + rest
+}
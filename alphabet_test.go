@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestAlphabets(t *testing.T) {
+	for name, a := range Alphabets {
+		if err := uniqueChars(a.Chars); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+
+	if err := uniqueChars(RandChars); err != nil {
+		t.Errorf("default chars: %s", err)
+	}
+
+	if err := uniqueChars("abca"); err == nil {
+		t.Error("expected error for repeated char")
+	}
+}
+
+func TestNormalizeAlias(t *testing.T) {
+	tests := []struct {
+		alphabet string
+		alias    string
+		norm     string
+	}{
+		{"crockford", "x-abc", "X-ABC"},
+		{"crockford", "X-oil", "X-011"},
+		{"unambiguous", "x-abc", "X-ABC"},
+		{"numeric", "x-123", "x-123"},
+		{"", "x-abc", "x-abc"},
+		// Prefix does not match.
+		{"crockford", "y-abc", "y-abc"},
+	}
+
+	for _, test := range tests {
+		def := &Def{Alphabet: test.alphabet, Prefix: "X-"}
+
+		if norm := normalizeAlias(def, test.alias); norm != test.norm {
+			t.Errorf("%s %s: expected %s, got %s", test.alphabet, test.alias, test.norm, norm)
+		}
+	}
+}
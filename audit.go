@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditRecord records a re-identification of aliases. The idents are not
+// recorded so the log does not hold identifying data itself.
+type AuditRecord struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	DefID   int       `json:"def_id"`
+	Def     string    `json:"def"`
+	Reason  string    `json:"reason"`
+	Remote  string    `json:"remote"`
+	Aliases []string  `json:"aliases"`
+	Found   int       `json:"found"`
+}
+
+// AuditLog appends records as JSON lines to a file. Each record is synced to
+// disk before it is acknowledged.
+type AuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenAuditLog opens the audit log file for appending, creating it if it
+// does not exist.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuditLog{file: f}, nil
+}
+
+// Write appends the record.
+func (l *AuditLog) Write(rec *AuditRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	return l.file.Sync()
+}
+
+// Close closes the file.
+func (l *AuditLog) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"strings"
+)
+
+var (
+	// MaxBlocklist is the maximum number of entries of a definition's
+	// blocklist.
+	MaxBlocklist = 1000
+
+	// Number of generated candidates of each definition that were rejected
+	// by its blocklist.
+	blockedAliases = expvar.NewMap("aliases_blocked")
+
+	// Lookalike digits are read as the letters they resemble when matching,
+	// so sh1t is blocked as well.
+	unleet = strings.NewReplacer(
+		"0", "o",
+		"1", "i",
+		"3", "e",
+		"4", "a",
+		"5", "s",
+		"7", "t",
+		"8", "b",
+	)
+)
+
+// profanityList holds offensive words blocked when a definition enables the
+// built-in list.
+var profanityList = []string{
+	"anal",
+	"anus",
+	"arse",
+	"ass",
+	"bastard",
+	"bitch",
+	"bollock",
+	"boner",
+	"boob",
+	"butt",
+	"chink",
+	"clit",
+	"cock",
+	"coon",
+	"crap",
+	"cum",
+	"cunt",
+	"damn",
+	"dick",
+	"dildo",
+	"dyke",
+	"fag",
+	"fuck",
+	"gook",
+	"homo",
+	"jizz",
+	"kike",
+	"kkk",
+	"nazi",
+	"nigg",
+	"penis",
+	"piss",
+	"poop",
+	"porn",
+	"prick",
+	"pube",
+	"puss",
+	"rape",
+	"retard",
+	"scrot",
+	"sex",
+	"shit",
+	"slut",
+	"spic",
+	"spunk",
+	"tit",
+	"turd",
+	"twat",
+	"vagina",
+	"wank",
+	"whore",
+}
+
+// Blocklist rejects aliases containing any of its substrings, ignoring case.
+type Blocklist struct {
+	subs []string
+}
+
+// NewBlocklist returns the blocklist of the definition, or nil if it has
+// none.
+func NewBlocklist(def *Def) *Blocklist {
+	if len(def.Block) == 0 && !def.Profanity {
+		return nil
+	}
+
+	b := &Blocklist{}
+
+	for _, s := range def.Block {
+		b.subs = append(b.subs, strings.ToLower(s))
+	}
+
+	if def.Profanity {
+		b.subs = append(b.subs, profanityList...)
+	}
+
+	return b
+}
+
+// Match returns true if the alias contains a blocked substring.
+func (b *Blocklist) Match(alias string) bool {
+	alias = strings.ToLower(alias)
+	plain := unleet.Replace(alias)
+
+	for _, s := range b.subs {
+		if strings.Contains(alias, s) || strings.Contains(plain, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateBlocklist checks the blocklist entries of the definition.
+func validateBlocklist(def *Def) error {
+	if len(def.Block) == 0 && !def.Profanity {
+		return nil
+	}
+
+	switch def.Type {
+	case "hmac", "uuidv5", "fpe":
+		// Retries derive aliases containing the same substring.
+		return fmt.Errorf("blocklist is not supported by %s", def.Type)
+	}
+
+	if len(def.Block) > MaxBlocklist {
+		return fmt.Errorf("blocklist may have at most %d entries", MaxBlocklist)
+	}
+
+	for _, s := range def.Block {
+		if s == "" {
+			return errors.New("blocklist entries may not be empty")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestBlocklist(t *testing.T) {
+	if NewBlocklist(&Def{}) != nil {
+		t.Error("expected no blocklist")
+	}
+
+	b := NewBlocklist(&Def{Block: []string{"O0", "xyz"}, Profanity: true})
+
+	tests := map[string]bool{
+		"abc123": false,
+		"aXYZb":  true,
+		"o0o":    true,
+		"5h1tty": true,
+		"SHIT":   true,
+		"q7k2m9": false,
+	}
+
+	for alias, match := range tests {
+		if b.Match(alias) != match {
+			t.Errorf("%s: expected match %v", alias, match)
+		}
+	}
+}
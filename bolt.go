@@ -0,0 +1,683 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// DefaultBoltTimeout is the time to wait for the lock on the database file.
+	DefaultBoltTimeout = time.Second
+
+	// Top-level buckets mirroring the Redis key prefixes.
+	defBucket   = []byte("d")
+	valueBucket = []byte("v")
+	seqBucket   = []byte("s")
+	countBucket = []byte("c")
+
+	// Bucket of per-definition buckets, each holding a key bucket
+	// (ident -> alias) and an alias bucket (alias -> true).
+	identBucket = []byte("i")
+	keyBucket   = []byte("k")
+	aliasBucket = []byte("a")
+
+	boltTrue = []byte("true")
+)
+
+// itob encodes an integer as a big-endian byte slice so keys sort numerically.
+func itob(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func btoi(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+// BoltStore is a Store backed by a local bbolt database file.
+//
+//	d/<name> -> <id>
+//	v/<id> -> { ... }
+//	s/<id> -> <seq>
+//	c/<id> -> <count>
+//	i/<id>/k/<ident> -> <alias>
+//	i/<id>/a/<alias> -> true
+type BoltStore struct {
+	DB *bolt.DB
+}
+
+// NewBoltStore opens or creates the database file at path.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout: DefaultBoltTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{defBucket, valueBucket, seqBucket, countBucket, identBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{DB: db}, nil
+}
+
+// Close closes the database file.
+func (s *BoltStore) Close() error {
+	return s.DB.Close()
+}
+
+func getBoltDef(tx *bolt.Tx, id []byte) (*Def, error) {
+	blob := tx.Bucket(valueBucket).Get(id)
+	if blob == nil {
+		panic(fmt.Sprintf("missing def value for %d", btoi(id)))
+	}
+
+	var def Def
+	if err := json.Unmarshal(blob, &def); err != nil {
+		return nil, err
+	}
+
+	return &def, nil
+}
+
+func putBoltDef(tx *bolt.Tx, def *Def) error {
+	b, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(valueBucket).Put(itob(int64(def.ID)), b)
+}
+
+// identBuckets returns the key and alias buckets of the definition. They are
+// created if writable is true, otherwise nil is returned if they do not exist.
+func identBuckets(tx *bolt.Tx, def *Def, writable bool) (*bolt.Bucket, *bolt.Bucket, error) {
+	id := itob(int64(def.ID))
+	root := tx.Bucket(identBucket)
+
+	if !writable {
+		b := root.Bucket(id)
+		if b == nil {
+			return nil, nil, nil
+		}
+		return b.Bucket(keyBucket), b.Bucket(aliasBucket), nil
+	}
+
+	b, err := root.CreateBucketIfNotExists(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys, err := b.CreateBucketIfNotExists(keyBucket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aliases, err := b.CreateBucketIfNotExists(aliasBucket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return keys, aliases, nil
+}
+
+// addBoltCount adds n to the alias count of the definition if it has been
+// initialized.
+func addBoltCount(tx *bolt.Tx, def *Def, n int) error {
+	counts := tx.Bucket(countBucket)
+	id := itob(int64(def.ID))
+
+	v := counts.Get(id)
+	if v == nil || n == 0 {
+		return nil
+	}
+
+	return counts.Put(id, itob(btoi(v)+int64(n)))
+}
+
+// CreateDef creates a new definition.
+func (s *BoltStore) CreateDef(def *Def) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		names := tx.Bucket(defBucket)
+
+		// Cannot create a def by the same name.
+		if names.Get([]byte(def.Name)) != nil {
+			return ErrDefExists
+		}
+
+		values := tx.Bucket(valueBucket)
+
+		id, err := values.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		def.ID = int(id)
+
+		if err := putBoltDef(tx, def); err != nil {
+			return err
+		}
+
+		if err := names.Put([]byte(def.Name), itob(int64(def.ID))); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(countBucket).Put(itob(int64(def.ID)), itob(0)); err != nil {
+			return err
+		}
+
+		// Initialize the sequence.
+		if def.Sequential() {
+			return tx.Bucket(seqBucket).Put(itob(int64(def.ID)), itob(def.seqInit()))
+		}
+
+		return nil
+	})
+}
+
+// GetDef retrieves an existing definition.
+func (s *BoltStore) GetDef(name string) (*Def, error) {
+	var def *Def
+
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(defBucket).Get([]byte(name))
+		if id == nil {
+			return ErrNoDef
+		}
+
+		var err error
+		def, err = getBoltDef(tx, id)
+		return err
+	})
+
+	return def, err
+}
+
+// GetDefByID retrieves a definition by ID.
+func (s *BoltStore) GetDefByID(id int) (*Def, error) {
+	var def *Def
+
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		key := itob(int64(id))
+		if tx.Bucket(valueBucket).Get(key) == nil {
+			return ErrNoDef
+		}
+
+		var err error
+		def, err = getBoltDef(tx, key)
+		return err
+	})
+
+	return def, err
+}
+
+// GetDefs retrieves the page of definitions ordered by ID.
+func (s *BoltStore) GetDefs(q DefsQuery) ([]*Def, int, error) {
+	defs := []*Def{}
+
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(valueBucket).ForEach(func(k, v []byte) error {
+			var def Def
+			if err := json.Unmarshal(v, &def); err != nil {
+				return err
+			}
+
+			defs = append(defs, &def)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defs, total := pageDefs(defs, q)
+
+	return defs, total, nil
+}
+
+// UpdateDef updates an existing definition.
+func (s *BoltStore) UpdateDef(name string, def *Def) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		names := tx.Bucket(defBucket)
+
+		// Delete previous definition.
+		if name != def.Name {
+			if err := names.Delete([]byte(name)); err != nil {
+				return err
+			}
+		}
+
+		if err := putBoltDef(tx, def); err != nil {
+			return err
+		}
+
+		return names.Put([]byte(def.Name), itob(int64(def.ID)))
+	})
+}
+
+// DelDef deletes the name entry and updates the definition.
+func (s *BoltStore) DelDef(def *Def) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(defBucket).Delete([]byte(def.Name)); err != nil {
+			return err
+		}
+
+		return putBoltDef(tx, def)
+	})
+}
+
+// RestoreDef sets the name entry unless it is taken and updates the
+// definition.
+func (s *BoltStore) RestoreDef(def *Def) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		id := itob(int64(def.ID))
+
+		if tx.Bucket(valueBucket).Get(id) == nil {
+			return ErrNoDef
+		}
+
+		names := tx.Bucket(defBucket)
+
+		if names.Get([]byte(def.Name)) != nil {
+			return ErrDefExists
+		}
+
+		if err := putBoltDef(tx, def); err != nil {
+			return err
+		}
+
+		return names.Put([]byte(def.Name), id)
+	})
+}
+
+// Lookup gets the aliases of the idents.
+func (s *BoltStore) Lookup(def *Def, idents []*IdentAlias) error {
+	return s.DB.View(func(tx *bolt.Tx) error {
+		keys, _, err := identBuckets(tx, def, false)
+		if err != nil {
+			return err
+		}
+
+		for _, ia := range idents {
+			var v []byte
+			if keys != nil {
+				v = keys.Get([]byte(ia.Ident))
+			}
+
+			if v == nil {
+				ia.Status = StatusMissing
+				continue
+			}
+
+			ia.Alias = string(v)
+			ia.Status = StatusExists
+		}
+
+		return nil
+	})
+}
+
+// Claim assigns the aliases to the idents if neither is taken. The batch
+// is committed in one transaction.
+func (s *BoltStore) Claim(def *Def, idents []*IdentAlias) error {
+	// Results are applied after the commit so a failed transaction leaves
+	// the idents untouched.
+	aliases := make([]string, len(idents))
+	statuses := make([]Status, len(idents))
+
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		keyB, aliasB, err := identBuckets(tx, def, true)
+		if err != nil {
+			return err
+		}
+
+		var created int
+
+		for i, ia := range idents {
+			if v := keyB.Get([]byte(ia.Ident)); v != nil {
+				aliases[i] = string(v)
+				statuses[i] = StatusExists
+				continue
+			}
+
+			if aliasB.Get([]byte(ia.Alias)) != nil {
+				aliases[i] = ""
+				statuses[i] = 0
+				continue
+			}
+
+			if err := keyB.Put([]byte(ia.Ident), []byte(ia.Alias)); err != nil {
+				return err
+			}
+
+			if err := aliasB.Put([]byte(ia.Alias), boltAliasValue(def, ia.Ident)); err != nil {
+				return err
+			}
+
+			aliases[i] = ia.Alias
+			statuses[i] = StatusCreated
+			created++
+		}
+
+		return addBoltCount(tx, def, created)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for i, ia := range idents {
+		ia.Alias = aliases[i]
+		ia.Status = statuses[i]
+	}
+
+	return nil
+}
+
+// Set sets the key and alias entries of the idents.
+func (s *BoltStore) Set(def *Def, idents []*IdentAlias) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		keys, aliases, err := identBuckets(tx, def, true)
+		if err != nil {
+			return err
+		}
+
+		var added int
+
+		for _, ia := range idents {
+			if aliases.Get([]byte(ia.Alias)) == nil {
+				added++
+			}
+
+			if err := keys.Put([]byte(ia.Ident), []byte(ia.Alias)); err != nil {
+				return err
+			}
+
+			if err := aliases.Put([]byte(ia.Alias), boltAliasValue(def, ia.Ident)); err != nil {
+				return err
+			}
+		}
+
+		return addBoltCount(tx, def, added)
+	})
+}
+
+// boltAliasValue returns the value of the alias entry, the ident if the
+// definition has a reverse index.
+func boltAliasValue(def *Def, ident string) []byte {
+	if def.Reverse {
+		return []byte(ident)
+	}
+
+	return boltTrue
+}
+
+// Reverse gets the idents of the aliases from the alias entries.
+func (s *BoltStore) Reverse(def *Def, aliases []*IdentAlias) error {
+	return s.DB.View(func(tx *bolt.Tx) error {
+		_, aliasB, err := identBuckets(tx, def, false)
+		if err != nil {
+			return err
+		}
+
+		for _, ia := range aliases {
+			var v []byte
+			if aliasB != nil && def.Reverse {
+				v = aliasB.Get([]byte(ia.Alias))
+			}
+
+			if v == nil {
+				ia.Status = StatusMissing
+				continue
+			}
+
+			ia.Ident = string(v)
+			ia.Status = StatusExists
+		}
+
+		return nil
+	})
+}
+
+// Del deletes the key and alias entries of the idents.
+func (s *BoltStore) Del(def *Def, idents []string) (int, error) {
+	var n int
+
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		n = 0
+
+		keys, aliases, err := identBuckets(tx, def, false)
+		if err != nil || keys == nil {
+			return err
+		}
+
+		for _, ident := range idents {
+			alias := keys.Get([]byte(ident))
+			if alias == nil {
+				continue
+			}
+
+			if err := aliases.Delete(alias); err != nil {
+				return err
+			}
+
+			if err := keys.Delete([]byte(ident)); err != nil {
+				return err
+			}
+
+			n++
+		}
+
+		return addBoltCount(tx, def, -n)
+	})
+
+	return n, err
+}
+
+// Idents returns all idents of the definition.
+func (s *BoltStore) Idents(def *Def) ([]string, error) {
+	var idents []string
+
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		keys, _, err := identBuckets(tx, def, false)
+		if err != nil || keys == nil {
+			return err
+		}
+
+		return keys.ForEach(func(k, _ []byte) error {
+			idents = append(idents, string(k))
+			return nil
+		})
+	})
+
+	return idents, err
+}
+
+// RenameIdents replaces the key entries of the idents in one transaction.
+func (s *BoltStore) RenameIdents(def *Def, from, to []string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		keys, _, err := identBuckets(tx, def, false)
+		if err != nil || keys == nil {
+			return err
+		}
+
+		for i, ident := range from {
+			alias := keys.Get([]byte(ident))
+			if alias == nil {
+				continue
+			}
+
+			if keys.Get([]byte(to[i])) != nil {
+				return fmt.Errorf("ident %s exists", to[i])
+			}
+
+			// The value is only valid until the entry is modified.
+			alias = append([]byte(nil), alias...)
+
+			if err := keys.Delete([]byte(ident)); err != nil {
+				return err
+			}
+
+			if err := keys.Put([]byte(to[i]), alias); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Count returns the alias count of the definition. The count of definitions
+// created before it was kept is initialized by counting the alias entries
+// once.
+func (s *BoltStore) Count(def *Def) (int, error) {
+	var (
+		n  int64
+		ok bool
+	)
+
+	id := itob(int64(def.ID))
+
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(countBucket).Get(id); v != nil {
+			n, ok = btoi(v), true
+		}
+
+		return nil
+	})
+
+	if err != nil || ok {
+		return int(n), err
+	}
+
+	err = s.DB.Update(func(tx *bolt.Tx) error {
+		counts := tx.Bucket(countBucket)
+
+		// Initialized by another call since the view.
+		if v := counts.Get(id); v != nil {
+			n = btoi(v)
+			return nil
+		}
+
+		_, aliases, err := identBuckets(tx, def, false)
+		if err != nil {
+			return err
+		}
+
+		n = 0
+		if aliases != nil {
+			n = int64(aliases.Stats().KeyN)
+		}
+
+		return counts.Put(id, itob(n))
+	})
+
+	return int(n), err
+}
+
+// NextSeq increments the sequence of the definition.
+func (s *BoltStore) NextSeq(def *Def, n int64) (int64, error) {
+	var last int64
+
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		seqs := tx.Bucket(seqBucket)
+		key := itob(int64(def.ID))
+
+		last = 0
+		if v := seqs.Get(key); v != nil {
+			last = btoi(v)
+		}
+
+		last += n
+
+		return seqs.Put(key, itob(last))
+	})
+
+	return last, err
+}
+
+// Purge deletes up to n key and alias entries of the definition in one
+// transaction. Once none are left the bucket of the definition is deleted.
+func (s *BoltStore) Purge(def *Def, n int) (int, bool, error) {
+	var (
+		removed int
+		done    bool
+	)
+
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		removed = 0
+		done = false
+
+		id := itob(int64(def.ID))
+		keys, aliases, err := identBuckets(tx, def, false)
+		if err != nil {
+			return err
+		}
+
+		if keys != nil {
+			// Collect the entries first since deleting while iterating
+			// moves the cursor.
+			var idents, values [][]byte
+
+			c := keys.Cursor()
+			for k, v := c.First(); k != nil && len(idents) < n; k, v = c.Next() {
+				idents = append(idents, append([]byte(nil), k...))
+				values = append(values, append([]byte(nil), v...))
+			}
+
+			for i, ident := range idents {
+				if err := aliases.Delete(values[i]); err != nil {
+					return err
+				}
+
+				if err := keys.Delete(ident); err != nil {
+					return err
+				}
+
+				removed++
+			}
+
+			if err := addBoltCount(tx, def, -removed); err != nil {
+				return err
+			}
+
+			if k, _ := keys.Cursor().First(); k != nil {
+				return nil
+			}
+
+			if err := tx.Bucket(identBucket).DeleteBucket(id); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Bucket(seqBucket).Delete(id); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(countBucket).Delete(id); err != nil {
+			return err
+		}
+
+		done = true
+
+		return tx.Bucket(valueBucket).Delete(id)
+	})
+
+	return removed, done, err
+}
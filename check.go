@@ -0,0 +1,339 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNoCheck is returned when validating aliases of a definition without a
+// check char.
+var ErrNoCheck = errors.New("def has no check char")
+
+const (
+	digitChars = "0123456789"
+	hexChars   = "0123456789abcdef"
+)
+
+// Checker computes and verifies check chars.
+type Checker interface {
+	// Compute returns the check char of the string.
+	Compute(s string) (byte, error)
+
+	// Verify returns true if the last char of the string is its check char.
+	Verify(s string) bool
+}
+
+// checkAlphabet returns the chars aliases of the definition are made of.
+func checkAlphabet(def *Def) string {
+	switch def.Type {
+	case "seq":
+		return digitChars
+	case "uuid", "uuidv5", "uuidv7":
+		return hexChars
+	case "ulid":
+		return crockford
+	case "pattern":
+		return patternAlphabet(def)
+	case "words":
+		alphabet := "abcdefghijklmnopqrstuvwxyz" + digitChars
+
+		// The separator is part of the alias.
+		sep := def.Separator
+		if sep == "" {
+			sep = DefaultWordsSeparator
+		}
+
+		for i := 0; i < len(sep); i++ {
+			if strings.IndexByte(alphabet, sep[i]) < 0 {
+				alphabet += sep[i : i+1]
+			}
+		}
+
+		return alphabet
+	}
+
+	return def.Chars
+}
+
+// NewChecker returns the checker of the definition, or nil if it has none.
+func NewChecker(def *Def) (Checker, error) {
+	alphabet := checkAlphabet(def)
+
+	switch def.Check {
+	case "":
+		return nil, nil
+
+	case "luhn":
+		return newLuhn(alphabet)
+
+	case "verhoeff", "damm":
+		for i := 0; i < len(alphabet); i++ {
+			if alphabet[i] < '0' || alphabet[i] > '9' {
+				return nil, fmt.Errorf("%s check requires numeric chars", def.Check)
+			}
+		}
+
+		if def.Check == "verhoeff" {
+			return verhoeff{}, nil
+		}
+
+		return damm{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown check '%s'", def.Check)
+}
+
+// appendCheck appends the check char of the alias after its prefix.
+func appendCheck(c Checker, prefix, alias string) (string, error) {
+	b, err := c.Compute(strings.TrimPrefix(alias, prefix))
+	if err != nil {
+		return "", err
+	}
+
+	return alias + string(b), nil
+}
+
+// verifyCheck verifies the check char of the alias after its prefix and
+// returns the alias without it.
+func verifyCheck(c Checker, prefix, alias string) (string, bool) {
+	if !strings.HasPrefix(alias, prefix) || len(alias) < len(prefix)+2 {
+		return "", false
+	}
+
+	if !c.Verify(alias[len(prefix):]) {
+		return "", false
+	}
+
+	return alias[:len(alias)-1], true
+}
+
+// checkDigits returns the values of the chars in the alphabet. Dashes are
+// skipped unless they are in the alphabet, so separators of formatted aliases
+// such as UUIDs do not need to be removed.
+func checkDigits(alphabet, s string) ([]int, error) {
+	d := make([]int, 0, len(s))
+
+	for i := 0; i < len(s); i++ {
+		v := strings.IndexByte(alphabet, s[i])
+
+		if v < 0 {
+			if s[i] == '-' {
+				continue
+			}
+			return nil, fmt.Errorf("char '%c' is not in the alphabet", s[i])
+		}
+
+		d = append(d, v)
+	}
+
+	return d, nil
+}
+
+// luhn implements the Luhn mod N algorithm over an alphabet of N chars. With
+// digits it is the Luhn algorithm.
+type luhn struct {
+	alphabet string
+}
+
+func newLuhn(alphabet string) (*luhn, error) {
+	if len(alphabet) < 2 {
+		return nil, errors.New("luhn check requires at least 2 chars")
+	}
+
+	for i := 0; i < len(alphabet); i++ {
+		if strings.IndexByte(alphabet, alphabet[i]) != i {
+			return nil, fmt.Errorf("luhn check requires unique chars, '%c' is repeated", alphabet[i])
+		}
+	}
+
+	return &luhn{alphabet: alphabet}, nil
+}
+
+// sum doubles every other digit starting from the rightmost if double is
+// true.
+func (l *luhn) sum(d []int, double bool) int {
+	var (
+		n   = len(l.alphabet)
+		sum int
+	)
+
+	for i := len(d) - 1; i >= 0; i-- {
+		v := d[i]
+
+		if double {
+			v *= 2
+			v = v/n + v%n
+		}
+
+		sum += v
+		double = !double
+	}
+
+	return sum % n
+}
+
+func (l *luhn) Compute(s string) (byte, error) {
+	d, err := checkDigits(l.alphabet, s)
+	if err != nil {
+		return 0, err
+	}
+
+	n := len(l.alphabet)
+
+	return l.alphabet[(n-l.sum(d, true))%n], nil
+}
+
+func (l *luhn) Verify(s string) bool {
+	d, err := checkDigits(l.alphabet, s)
+	if err != nil || len(d) < 2 {
+		return false
+	}
+
+	return l.sum(d, false) == 0
+}
+
+var (
+	verhoeffD = [10][10]int{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		{1, 2, 3, 4, 0, 6, 7, 8, 9, 5},
+		{2, 3, 4, 0, 1, 7, 8, 9, 5, 6},
+		{3, 4, 0, 1, 2, 8, 9, 5, 6, 7},
+		{4, 0, 1, 2, 3, 9, 5, 6, 7, 8},
+		{5, 9, 8, 7, 6, 0, 4, 3, 2, 1},
+		{6, 5, 9, 8, 7, 1, 0, 4, 3, 2},
+		{7, 6, 5, 9, 8, 2, 1, 0, 4, 3},
+		{8, 7, 6, 5, 9, 3, 2, 1, 0, 4},
+		{9, 8, 7, 6, 5, 4, 3, 2, 1, 0},
+	}
+
+	verhoeffP = [8][10]int{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		{1, 5, 7, 6, 2, 8, 3, 0, 9, 4},
+		{5, 8, 0, 3, 7, 9, 6, 1, 4, 2},
+		{8, 9, 1, 6, 0, 4, 3, 5, 2, 7},
+		{9, 4, 5, 3, 1, 2, 8, 7, 6, 0},
+		{4, 2, 8, 6, 5, 7, 3, 9, 0, 1},
+		{2, 7, 9, 3, 8, 0, 6, 4, 1, 5},
+		{7, 0, 4, 6, 9, 1, 3, 2, 5, 8},
+	}
+
+	verhoeffInv = [10]int{0, 4, 3, 2, 1, 5, 6, 7, 8, 9}
+
+	dammTable = [10][10]int{
+		{0, 3, 1, 7, 5, 9, 8, 6, 4, 2},
+		{7, 0, 9, 2, 1, 5, 4, 8, 6, 3},
+		{4, 2, 0, 6, 8, 7, 1, 3, 5, 9},
+		{1, 7, 5, 0, 9, 8, 3, 4, 2, 6},
+		{6, 1, 2, 3, 0, 4, 5, 9, 7, 8},
+		{3, 6, 7, 4, 2, 0, 9, 5, 8, 1},
+		{5, 8, 6, 9, 7, 2, 0, 1, 3, 4},
+		{8, 9, 4, 5, 3, 6, 2, 0, 1, 7},
+		{9, 4, 3, 8, 6, 1, 7, 2, 0, 5},
+		{2, 5, 8, 1, 4, 3, 6, 7, 9, 0},
+	}
+)
+
+// verhoeff implements the Verhoeff algorithm, which detects all single digit
+// errors and transpositions of adjacent digits.
+type verhoeff struct{}
+
+// check returns the checksum of the digits, offsetting the positions by one
+// when computing a check digit to be appended.
+func (verhoeff) check(d []int, offset int) int {
+	var c int
+
+	for i := range d {
+		c = verhoeffD[c][verhoeffP[(i+offset)%8][d[len(d)-1-i]]]
+	}
+
+	return c
+}
+
+func (v verhoeff) Compute(s string) (byte, error) {
+	d, err := checkDigits(digitChars, s)
+	if err != nil {
+		return 0, err
+	}
+
+	return digitChars[verhoeffInv[v.check(d, 1)]], nil
+}
+
+func (v verhoeff) Verify(s string) bool {
+	d, err := checkDigits(digitChars, s)
+	if err != nil || len(d) < 2 {
+		return false
+	}
+
+	return v.check(d, 0) == 0
+}
+
+// damm implements the Damm algorithm, which detects all single digit errors
+// and transpositions of adjacent digits.
+type damm struct{}
+
+func (damm) check(d []int) int {
+	var c int
+
+	for _, v := range d {
+		c = dammTable[c][v]
+	}
+
+	return c
+}
+
+func (m damm) Compute(s string) (byte, error) {
+	d, err := checkDigits(digitChars, s)
+	if err != nil {
+		return 0, err
+	}
+
+	return digitChars[m.check(d)], nil
+}
+
+func (m damm) Verify(s string) bool {
+	d, err := checkDigits(digitChars, s)
+	if err != nil || len(d) < 2 {
+		return false
+	}
+
+	return m.check(d) == 0
+}
+
+// patternAlphabet returns the chars of the pattern elements in order of
+// appearance.
+func patternAlphabet(def *Def) string {
+	parts, err := ParsePattern(def.Pattern, def.Chars)
+	if err != nil {
+		return ""
+	}
+
+	var b []byte
+
+	add := func(s string) {
+		for i := 0; i < len(s); i++ {
+			if strings.IndexByte(string(b), s[i]) < 0 {
+				b = append(b, s[i])
+			}
+		}
+	}
+
+	for _, p := range parts {
+		switch {
+		case p.class != "":
+			add(p.class)
+		case p.lit != "":
+			add(p.lit)
+		case p.seq, p.date != "":
+			add(digitChars)
+		}
+
+		// Literal chars of date formats.
+		if p.date != "" {
+			add(formatDate(p.date, time.Time{}))
+		}
+	}
+
+	return string(b)
+}
@@ -0,0 +1,108 @@
+package main
+
+import "testing"
+
+func TestCheckers(t *testing.T) {
+	tests := []struct {
+		check string
+		chars string
+		in    string
+		out   string
+	}{
+		{"luhn", digitChars, "7992739871", "79927398713"},
+		{"luhn", hexChars, "1f2e", "1f2e1"},
+		{"luhn", "abcdef", "abcdef", "abcdefe"},
+		{"verhoeff", digitChars, "236", "2363"},
+		{"verhoeff", digitChars, "12345", "123451"},
+		{"damm", digitChars, "572", "5724"},
+	}
+
+	for _, test := range tests {
+		c, err := NewChecker(&Def{Type: "rand", Chars: test.chars, Check: test.check})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := appendCheck(c, "", test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if out != test.out {
+			t.Errorf("%s: expected %s, got %s", test.check, test.out, out)
+		}
+
+		if _, ok := verifyCheck(c, "", out); !ok {
+			t.Errorf("%s: expected %s to be valid", test.check, out)
+		}
+
+		// Single substitution.
+		b := []byte(out)
+		b[0] = test.chars[(indexOf(test.chars, b[0])+1)%len(test.chars)]
+
+		if _, ok := verifyCheck(c, "", string(b)); ok {
+			t.Errorf("%s: expected %s to be invalid", test.check, b)
+		}
+
+		// Adjacent transposition.
+		b = []byte(out)
+		if b[0] != b[1] {
+			b[0], b[1] = b[1], b[0]
+
+			if _, ok := verifyCheck(c, "", string(b)); ok {
+				t.Errorf("%s: expected %s to be invalid", test.check, b)
+			}
+		}
+	}
+}
+
+func indexOf(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestCheckerPrefix(t *testing.T) {
+	c, err := NewChecker(&Def{Type: "seq", Check: "damm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alias, err := appendCheck(c, "SUBJ-", "SUBJ-572")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if alias != "SUBJ-5724" {
+		t.Errorf("unexpected %s", alias)
+	}
+
+	body, ok := verifyCheck(c, "SUBJ-", alias)
+	if !ok || body != "SUBJ-572" {
+		t.Errorf("expected %s to be valid", alias)
+	}
+
+	for _, alias := range []string{"5724", "SUBJ-5742", "SUBJ-", "SUBJ-x724"} {
+		if _, ok := verifyCheck(c, "SUBJ-", alias); ok {
+			t.Errorf("expected %s to be invalid", alias)
+		}
+	}
+}
+
+func TestNewCheckerInvalid(t *testing.T) {
+	defs := []*Def{
+		{Type: "rand", Chars: "abcdefgh", Check: "damm"},
+		{Type: "uuid", Check: "verhoeff"},
+		{Type: "rand", Chars: "abcdefga", Check: "luhn"},
+		{Type: "seq", Check: "crc"},
+	}
+
+	for _, def := range defs {
+		if _, err := NewChecker(def); err == nil {
+			t.Errorf("expected error for %s check of %s", def.Check, def.Type)
+		}
+	}
+}
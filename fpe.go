@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	// FF1MinDomain is the minimum number of possible values of an input to
+	// FF1, i.e. radix^n, as required by NIST SP 800-38G Rev. 1.
+	FF1MinDomain = big.NewInt(1000000)
+
+	// MaxFPETweakLen is the maximum tweak length in bytes.
+	MaxFPETweakLen = 32
+	// DefaultFPETweakLen is the length in bytes of generated tweaks.
+	DefaultFPETweakLen = 8
+)
+
+// FF1 implements the FF1 format-preserving encryption mode of NIST SP 800-38G
+// using AES. Numeral strings are slices of digits less than the radix.
+type FF1 struct {
+	block cipher.Block
+	radix int
+	tweak []byte
+}
+
+// NewFF1 returns an FF1 cipher for the AES key, radix, and tweak.
+func NewFF1(key []byte, radix int, tweak []byte) (*FF1, error) {
+	if radix < 2 || radix > 1<<16 {
+		return nil, errors.New("radix must be between 2 and 65536")
+	}
+
+	if len(tweak) > MaxFPETweakLen {
+		return nil, fmt.Errorf("tweak must be at most %d bytes", MaxFPETweakLen)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FF1{
+		block: block,
+		radix: radix,
+		tweak: tweak,
+	}, nil
+}
+
+// Encrypt encrypts the numeral string.
+func (f *FF1) Encrypt(x []int) ([]int, error) {
+	return f.cipher(x, true)
+}
+
+// Decrypt decrypts the numeral string.
+func (f *FF1) Decrypt(x []int) ([]int, error) {
+	return f.cipher(x, false)
+}
+
+// num returns the number represented by the numeral string, most significant
+// numeral first.
+func (f *FF1) num(x []int) *big.Int {
+	var (
+		n     = new(big.Int)
+		radix = big.NewInt(int64(f.radix))
+	)
+
+	for _, d := range x {
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(d)))
+	}
+
+	return n
+}
+
+// str returns the m numerals representing n.
+func (f *FF1) str(n *big.Int, m int) []int {
+	var (
+		x     = make([]int, m)
+		radix = big.NewInt(int64(f.radix))
+		d     = new(big.Int)
+		n2    = new(big.Int).Set(n)
+	)
+
+	for i := m - 1; i >= 0; i-- {
+		n2.DivMod(n2, radix, d)
+		x[i] = int(d.Int64())
+	}
+
+	return x
+}
+
+// prf computes the CBC-MAC of the input with a zero IV.
+func (f *FF1) prf(in []byte) []byte {
+	y := make([]byte, aes.BlockSize)
+
+	for i := 0; i < len(in); i += aes.BlockSize {
+		for j := range y {
+			y[j] ^= in[i+j]
+		}
+		f.block.Encrypt(y, y)
+	}
+
+	return y
+}
+
+func (f *FF1) cipher(x []int, encrypt bool) ([]int, error) {
+	n := len(x)
+	if n < 2 {
+		return nil, errors.New("input must be at least 2 numerals")
+	}
+
+	for _, d := range x {
+		if d < 0 || d >= f.radix {
+			return nil, errors.New("numeral out of range")
+		}
+	}
+
+	radix := big.NewInt(int64(f.radix))
+
+	if new(big.Int).Exp(radix, big.NewInt(int64(n)), nil).Cmp(FF1MinDomain) < 0 {
+		return nil, fmt.Errorf("input domain must be at least %s", FF1MinDomain)
+	}
+
+	var (
+		u = n / 2
+		v = n - u
+		t = len(f.tweak)
+	)
+
+	// Byte length of the numbers and of the pseudorandom output.
+	vmax := new(big.Int).Exp(radix, big.NewInt(int64(v)), nil)
+	b := (vmax.Sub(vmax, big.NewInt(1)).BitLen() + 7) / 8
+	d := 4*((b+3)/4) + 4
+
+	p := []byte{1, 2, 1, byte(f.radix >> 16), byte(f.radix >> 8), byte(f.radix), 10, byte(u)}
+	p = append(p, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(p[8:], uint32(n))
+	binary.BigEndian.PutUint32(p[12:], uint32(t))
+
+	// The tweak and round number are followed by the number so Q fills a
+	// whole number of blocks.
+	pad := (16 - (t+b+1)%16) % 16
+	q := make([]byte, t+pad+1+b)
+	copy(q, f.tweak)
+
+	a := append([]int(nil), x[:u]...)
+	c := append([]int(nil), x[u:]...)
+
+	var (
+		y   = new(big.Int)
+		mod = new(big.Int)
+		s   = make([]byte, 0, d+aes.BlockSize)
+		buf = make([]byte, aes.BlockSize)
+	)
+
+	for r := 0; r < 10; r++ {
+		i := r
+		if !encrypt {
+			i = 9 - r
+		}
+
+		// The half that is not changed by this round.
+		in := c
+		if !encrypt {
+			in = a
+		}
+
+		q[t+pad] = byte(i)
+
+		for j := t + pad + 1; j < len(q); j++ {
+			q[j] = 0
+		}
+		f.num(in).FillBytes(q[t+pad+1:])
+
+		rb := f.prf(append(append([]byte(nil), p...), q...))
+
+		// Extend R to d bytes by encrypting it XORed with a counter.
+		s = append(s[:0], rb...)
+
+		for j := 1; len(s) < d; j++ {
+			copy(buf, rb)
+
+			var ctr [aes.BlockSize]byte
+			binary.BigEndian.PutUint64(ctr[8:], uint64(j))
+
+			for k := range buf {
+				buf[k] ^= ctr[k]
+			}
+
+			f.block.Encrypt(buf, buf)
+			s = append(s, buf...)
+		}
+
+		y.SetBytes(s[:d])
+
+		m := u
+		if i%2 == 1 {
+			m = v
+		}
+
+		mod.Exp(radix, big.NewInt(int64(m)), nil)
+
+		if encrypt {
+			z := f.num(a)
+			z.Add(z, y).Mod(z, mod)
+			a, c = c, f.str(z, m)
+		} else {
+			z := f.num(c)
+			z.Sub(z, y).Mod(z, mod)
+			a, c = f.str(z, m), a
+		}
+	}
+
+	return append(a, c...), nil
+}
+
+// FPEGen encrypts the ident with FF1 so the alias has the same length and
+// alphabet as the ident and can be decrypted with the key.
+type FPEGen struct {
+	Chars string
+
+	ff1   *FF1
+	index map[byte]int
+}
+
+// NewFPEGen returns a generator for idents made of the chars. The radix is
+// the number of chars.
+func NewFPEGen(key []byte, chars string, tweak []byte) (*FPEGen, error) {
+	index := make(map[byte]int, len(chars))
+
+	for i := 0; i < len(chars); i++ {
+		if _, ok := index[chars[i]]; ok {
+			return nil, fmt.Errorf("duplicate char '%c'", chars[i])
+		}
+		index[chars[i]] = i
+	}
+
+	ff1, err := NewFF1(key, len(chars), tweak)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FPEGen{
+		Chars: chars,
+		ff1:   ff1,
+		index: index,
+	}, nil
+}
+
+// New is not supported since the alias is derived from the ident.
+func (g *FPEGen) New() (string, error) {
+	return "", errors.New("fpe aliases require an ident")
+}
+
+func (g *FPEGen) apply(s string, encrypt bool) (string, error) {
+	x := make([]int, len(s))
+
+	for i := 0; i < len(s); i++ {
+		d, ok := g.index[s[i]]
+		if !ok {
+			return "", fmt.Errorf("char '%c' is not in the alphabet", s[i])
+		}
+		x[i] = d
+	}
+
+	var (
+		y   []int
+		err error
+	)
+
+	if encrypt {
+		y, err = g.ff1.Encrypt(x)
+	} else {
+		y, err = g.ff1.Decrypt(x)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, len(y))
+	for i, d := range y {
+		b[i] = g.Chars[d]
+	}
+
+	return string(b), nil
+}
+
+// NewFor encrypts the ident. Since the cipher is a permutation there is no
+// other candidate if the alias is taken.
+func (g *FPEGen) NewFor(ident string, attempt int) (string, error) {
+	if attempt > 0 {
+		return "", &IdentError{Ident: ident, Err: ErrDerivedTaken}
+	}
+
+	alias, err := g.apply(ident, true)
+	if err != nil {
+		return "", &IdentError{Ident: ident, Err: err}
+	}
+
+	return alias, nil
+}
+
+// Reverse decrypts the alias to the ident.
+func (g *FPEGen) Reverse(alias string) (string, error) {
+	return g.apply(alias, false)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// NIST SP 800-38G FF1 samples.
+var ff1Samples = []struct {
+	key   string
+	tweak string
+	chars string
+	pt    string
+	ct    string
+}{
+	{"2B7E151628AED2A6ABF7158809CF4F3C", "", digits, "0123456789", "2433477484"},
+	{"2B7E151628AED2A6ABF7158809CF4F3C", "39383736353433323130", digits, "0123456789", "6124200773"},
+	{"2B7E151628AED2A6ABF7158809CF4F3C", "3737373770717273373737", alnum, "0123456789abcdefghi", "a9tv40mll9kdu509eum"},
+	{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F", "", digits, "0123456789", "2830668132"},
+	{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F", "39383736353433323130", digits, "0123456789", "2496655549"},
+	{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F", "3737373770717273373737", alnum, "0123456789abcdefghi", "xbj3kv35jrawxv32ysr"},
+	{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94", "", digits, "0123456789", "6657667009"},
+	{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94", "39383736353433323130", digits, "0123456789", "1001623463"},
+	{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94", "3737373770717273373737", alnum, "0123456789abcdefghi", "xs8a0azh2avyalyzuwd"},
+}
+
+const (
+	digits = "0123456789"
+	alnum  = "0123456789abcdefghijklmnopqrstuvwxyz"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestFF1Samples(t *testing.T) {
+	for i, s := range ff1Samples {
+		g, err := NewFPEGen(mustHex(t, s.key), s.chars, mustHex(t, s.tweak))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ct, err := g.NewFor(s.pt, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ct != s.ct {
+			t.Errorf("sample %d: expected %s, got %s", i+1, s.ct, ct)
+		}
+
+		pt, err := g.Reverse(ct)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pt != s.pt {
+			t.Errorf("sample %d: expected %s to decrypt to %s, got %s", i+1, ct, s.pt, pt)
+		}
+	}
+}
+
+func TestFPEGenInvalid(t *testing.T) {
+	key := mustHex(t, "2B7E151628AED2A6ABF7158809CF4F3C")
+
+	if _, err := NewFPEGen(key, "0123456789a0", nil); err == nil {
+		t.Error("expected error for duplicate chars")
+	}
+
+	if _, err := NewFPEGen(key[:10], digits, nil); err == nil {
+		t.Error("expected error for bad key size")
+	}
+
+	g, err := NewFPEGen(key, digits, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Domain is too small.
+	if _, err := g.NewFor("12345", 0); err == nil {
+		t.Error("expected error for short ident")
+	}
+
+	if _, err := g.NewFor("12345a7890", 0); err == nil {
+		t.Error("expected error for char outside the alphabet")
+	}
+
+	if _, err := g.NewFor("1234567890", 1); err == nil {
+		t.Error("expected error for taken alias")
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"math/rand"
+	"math"
+	"math/big"
 	"strconv"
 	"time"
 
-	"github.com/garyburd/redigo/redis"
 	uuid "github.com/satori/go.uuid"
 )
 
@@ -14,18 +19,17 @@ var (
 	// RandMinlen is the default alias length for random alias generators.
 	RandMinlen = 8
 	// RandChars is the default character set for random alias generators.
-	RandChars = "abcdefghijklmnopqrstuvwzyz0123456789"
+	RandChars = "abcdefghijklmnopqrstuvwxyz0123456789"
 
 	// MinRandMinlen is the minimum alias length allowed for random alias generators.
 	MinRandMinlen = 4
 	// MinRandChars is the minimum number of characters allowed in a random alias
 	// generator character set.
 	MinRandChars = 8
-)
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
+	// MaxSeqWidth is the maximum zero-padded width of sequential aliases.
+	MaxSeqWidth = 19
+)
 
 // Def is an alias generator definition.
 type Def struct {
@@ -38,17 +42,80 @@ type Def struct {
 	// Type of generator.
 	Type string `json:"type"`
 
-	// Offset for seq generator.
-	// **NOT IMPLEMENTED**
+	// Apply to seq, hashid, and pattern generators. The sequence starts at
+	// Offset and is incremented by Step. The number is zero-padded to Width
+	// digits.
 	Offset int64 `json:"offset"`
+	Step   int64 `json:"step"`
+	Width  int   `json:"width"`
 
-	// Apply to rand generator.
+	// Apply to rand, hmac, and hashid generators. Chars is also the alphabet
+	// of the fpe generator and the * element of patterns.
 	Chars  string `json:"chars"`
 	Minlen int    `json:"minlen"`
+
+	// Name of a preset in Alphabets that replaces Chars. Aliases of
+	// case-insensitive alphabets are matched ignoring case.
+	Alphabet string `json:"alphabet,omitempty"`
+
+	// Apply to hmac, fpe, and hashid generators. Name of the secret in the
+	// server keyring.
+	Key string `json:"key,omitempty"`
+
+	// Apply to pattern generator. See ParsePattern for the syntax.
+	Pattern string `json:"pattern,omitempty"`
+
+	// Apply to words generator. Number of words, the separator between them,
+	// and the number of digits of the numeric suffix.
+	Words     int    `json:"words,omitempty"`
+	Separator string `json:"separator,omitempty"`
+	Digits    int    `json:"digits,omitempty"`
+
+	// Apply to uuidv5 generator. Namespace UUID or the name of a predefined
+	// namespace: dns, url, oid, or x500.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Apply to fpe generator. Hex-encoded tweak, generated on creation if
+	// not set, so the same ident is encrypted differently per definition.
+	Tweak string `json:"tweak,omitempty"`
+
+	// Apply to rand, words, and pattern generators. Source of randomness,
+	// crypto by default, or math seeded with Seed for reproducible aliases
+	// in tests.
+	Source string `json:"source,omitempty"`
+	Seed   int64  `json:"seed,omitempty"`
+
+	// Apply to all generators except uuid and fpe.
 	Prefix string `json:"prefix"`
 
+	// Check char appended to aliases to detect transcription errors: luhn for
+	// Luhn mod N over the chars of the alias, or verhoeff or damm for numeric
+	// aliases. It is computed after the prefix.
+	Check string `json:"check,omitempty"`
+
+	// Substrings generated aliases may not contain, ignoring case. Profanity
+	// adds the built-in list of offensive words.
+	Block     []string `json:"block,omitempty"`
+	Profanity bool     `json:"profanity,omitempty"`
+
+	// Name of the keyring secret idents are replaced with the HMAC of before
+	// they are stored, so the store never holds the raw idents.
+	IdentKey string `json:"ident_key,omitempty"`
+
+	// Whether the stored idents are being replaced with their HMAC by
+	// Server.MigrateIdents. Idents cannot be passed to the definition until
+	// it is done.
+	Migrating bool `json:"migrating,omitempty"`
+
+	// Whether the alias entries hold the ident so aliases can be looked up
+	// by Server.Reverse. It can only be set when the definition is created.
+	Reverse bool `json:"reverse,omitempty"`
+
 	// Whether the definition is archived or not.
 	Deleted bool `json:"archived"`
+
+	// Time the definition was archived.
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
 }
 
 // NewDef returns a new alias generator definition with the default settings.
@@ -56,32 +123,173 @@ func NewDef() *Def {
 	return &Def{
 		Chars:  RandChars,
 		Minlen: RandMinlen,
+		Offset: 1,
+		Step:   1,
 	}
 }
 
-// MakeGen makes an alias generator from the given definition given a redis connection.
-func MakeGen(c redis.Conn, d *Def) Gen {
+// MakeGen makes an alias generator from the given definition given a store
+// and the keyring holding the secrets of keyed generators.
+func MakeGen(st Store, keys Keyring, d *Def) (Gen, error) {
 	switch d.Type {
 	case "uuid":
-		return &UUIDGen{}
+		return &UUIDGen{}, nil
+
+	case "words":
+		src, err := defSource(st, d)
+		if err != nil {
+			return nil, err
+		}
+
+		g := newWordsGen(d)
+		g.Source = src
+
+		return g, nil
+
+	case "pattern":
+		parts, err := ParsePattern(d.Pattern, d.Chars)
+		if err != nil {
+			return nil, err
+		}
+
+		src, err := defSource(st, d)
+		if err != nil {
+			return nil, err
+		}
+
+		return &PatternGen{
+			Prefix: d.Prefix,
+			Now:    time.Now,
+			Source: src,
+			parts:  parts,
+			seq:    newSeqGen(st, d),
+		}, nil
+
+	case "uuidv5":
+		ns, err := parseNamespace(d.Namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		return &UUIDv5Gen{
+			Namespace: ns,
+			Prefix:    d.Prefix,
+		}, nil
+
+	case "ulid":
+		return &ULIDGen{
+			Prefix: d.Prefix,
+			source: ulidSource,
+		}, nil
+
+	case "uuidv7":
+		return &UUIDv7Gen{
+			Prefix: d.Prefix,
+			source: uuidv7Source,
+		}, nil
 
 	case "rand":
+		src, err := defSource(st, d)
+		if err != nil {
+			return nil, err
+		}
+
 		return &RandGen{
 			Prefix:  d.Prefix,
 			Minlen:  d.Minlen,
 			Chars:   d.Chars,
+			Source:  src,
 			charlen: len(d.Chars),
+		}, nil
+
+	case "hmac":
+		key, err := keys.Get(d.Key)
+		if err != nil {
+			return nil, err
 		}
 
-	case "seq":
-		return &SeqGen{
+		return &HMACGen{
+			Key:    key,
 			Name:   d.Name,
-			Offset: d.Offset,
-			conn:   c,
+			Prefix: d.Prefix,
+			Minlen: d.Minlen,
+			Chars:  d.Chars,
+		}, nil
+
+	case "fpe":
+		key, err := keys.Get(d.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		tweak, err := hex.DecodeString(d.Tweak)
+		if err != nil {
+			return nil, err
 		}
+
+		return NewFPEGen(key, d.Chars, tweak)
+
+	case "seq":
+		return newSeqGen(st, d), nil
+
+	case "hashid":
+		key, err := keys.Get(d.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		// Salt the alphabet per definition.
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(strconv.Itoa(d.ID)))
+
+		return NewHashidGen(newSeqGen(st, d), d.Chars, mac.Sum(nil), d.Minlen, d.Prefix)
+	}
+
+	return nil, fmt.Errorf("unknown type '%s'", d.Type)
+}
+
+// Sequential returns true if the generator of the definition uses its
+// sequence.
+func (d *Def) Sequential() bool {
+	return d.Type == "seq" || d.Type == "hashid" || d.Type == "pattern"
+}
+
+// aliasPrefix returns the prefix of the aliases of the definition, which is
+// empty if the generator does not apply it.
+func (d *Def) aliasPrefix() string {
+	if d.Type == "uuid" || d.Type == "fpe" {
+		return ""
+	}
+
+	return d.Prefix
+}
+
+// seqStep returns the increment of the sequence of the definition.
+func (d *Def) seqStep() int64 {
+	// Definitions created before the step was supported.
+	if d.Step == 0 {
+		return 1
 	}
 
-	return nil
+	return d.Step
+}
+
+// seqInit returns the value the sequence of the definition is initialized
+// with, one step before the offset so the first value is the offset.
+func (d *Def) seqInit() int64 {
+	return d.Offset - d.seqStep()
+}
+
+func newSeqGen(st Store, d *Def) *SeqGen {
+	return &SeqGen{
+		Name:   d.Name,
+		Offset: d.Offset,
+		Step:   d.seqStep(),
+		Width:  d.Width,
+		Prefix: d.Prefix,
+		def:    d,
+		store:  st,
+	}
 }
 
 // Gen is an alias generator interface.
@@ -89,6 +297,92 @@ type Gen interface {
 	New() (string, error)
 }
 
+// BatchGen is implemented by generators that can generate many aliases more
+// efficiently than calling New repeatedly.
+type BatchGen interface {
+	NewN(n int) ([]string, error)
+}
+
+// IdentGen is implemented by generators that derive the alias from the ident.
+// The attempt is the number of previous candidates for the ident that were
+// taken, so a different alias can be derived.
+type IdentGen interface {
+	NewFor(ident string, attempt int) (string, error)
+}
+
+// EntropyGen is implemented by generators that can report the number of
+// random bits of their aliases.
+type EntropyGen interface {
+	Entropy() float64
+}
+
+// SpaceGen is implemented by generators that can report the number of
+// distinct aliases they can generate. Nil is returned if it is unbounded.
+type SpaceGen interface {
+	Space() *big.Int
+}
+
+// ReverseGen is implemented by generators whose aliases can be converted back
+// to the ident.
+type ReverseGen interface {
+	Reverse(alias string) (string, error)
+}
+
+// ErrDerivedTaken is returned when the only alias a generator can derive from
+// the ident is assigned to another ident. This happens if the alias was set
+// explicitly.
+var ErrDerivedTaken = errors.New("alias derived from the ident is taken")
+
+// IdentError is returned when an ident cannot be aliased by the generator.
+type IdentError struct {
+	Ident string
+	Err   error
+}
+
+func (e *IdentError) Error() string {
+	return fmt.Sprintf("ident '%s': %s", e.Ident, e.Err)
+}
+
+// genFor generates an alias for each ident, deriving it from the ident if
+// supported by the generator.
+func genFor(g Gen, idents []*IdentAlias, attempt int) ([]string, error) {
+	ig, ok := g.(IdentGen)
+	if !ok {
+		return genN(g, len(idents))
+	}
+
+	aliases := make([]string, len(idents))
+
+	for i, ia := range idents {
+		alias, err := ig.NewFor(ia.Ident, attempt)
+		if err != nil {
+			return nil, err
+		}
+		aliases[i] = alias
+	}
+
+	return aliases, nil
+}
+
+// genN generates n aliases, in a batch if supported by the generator.
+func genN(g Gen, n int) ([]string, error) {
+	if bg, ok := g.(BatchGen); ok {
+		return bg.NewN(n)
+	}
+
+	aliases := make([]string, n)
+
+	for i := range aliases {
+		alias, err := g.New()
+		if err != nil {
+			return nil, err
+		}
+		aliases[i] = alias
+	}
+
+	return aliases, nil
+}
+
 // UUIDGen generates random UUIDs.
 type UUIDGen struct{}
 
@@ -97,21 +391,74 @@ func (g *UUIDGen) New() (string, error) {
 	return uuid.NewV4().String(), nil
 }
 
+// Predefined namespaces of uuidv5 generators.
+var uuidNamespaces = map[string]uuid.UUID{
+	"dns":  uuid.NamespaceDNS,
+	"url":  uuid.NamespaceURL,
+	"oid":  uuid.NamespaceOID,
+	"x500": uuid.NamespaceX500,
+}
+
+func parseNamespace(s string) (uuid.UUID, error) {
+	if ns, ok := uuidNamespaces[s]; ok {
+		return ns, nil
+	}
+
+	return uuid.FromString(s)
+}
+
+// UUIDv5Gen derives version 5 UUIDs from the ident within the namespace, so
+// the same ident always produces the same alias.
+type UUIDv5Gen struct {
+	Namespace uuid.UUID
+	Prefix    string
+}
+
+// New is not supported since the alias is derived from the ident.
+func (g *UUIDv5Gen) New() (string, error) {
+	return "", errors.New("uuidv5 aliases require an ident")
+}
+
+// NewFor derives the UUID of the ident. There is no other candidate if the
+// alias is taken.
+func (g *UUIDv5Gen) NewFor(ident string, attempt int) (string, error) {
+	if attempt > 0 {
+		return "", &IdentError{Ident: ident, Err: ErrDerivedTaken}
+	}
+
+	return g.Prefix + uuid.NewV5(g.Namespace, ident).String(), nil
+}
+
 // RandGen is a random alias generator.
 type RandGen struct {
 	Prefix string
 	Minlen int
 	Chars  string
+	Source Source
 
 	charlen int
 }
 
+// Entropy returns the number of random bits of an alias.
+func (g *RandGen) Entropy() float64 {
+	return float64(g.Minlen) * math.Log2(float64(g.charlen))
+}
+
+// Space returns the number of distinct aliases.
+func (g *RandGen) Space() *big.Int {
+	return new(big.Int).Exp(big.NewInt(int64(g.charlen)), big.NewInt(int64(g.Minlen)), nil)
+}
+
 // New generates a new random alias.
 func (g *RandGen) New() (string, error) {
 	key := make([]byte, g.Minlen)
 
 	for i := range key {
-		key[i] = g.Chars[rand.Intn(g.charlen)]
+		j, err := g.Source.Intn(g.charlen)
+		if err != nil {
+			return "", err
+		}
+		key[i] = g.Chars[j]
 	}
 
 	alias := string(key)
@@ -127,15 +474,145 @@ func (g *RandGen) New() (string, error) {
 type SeqGen struct {
 	Name   string
 	Offset int64
+	Step   int64
+	Width  int
+	Prefix string
 
-	conn redis.Conn
+	def   *Def
+	store Store
+}
+
+// Space returns the number of values from the offset that fit the width.
+func (g *SeqGen) Space() *big.Int {
+	return seqSpace(g.Offset, g.Step, g.Width)
+}
+
+// seqSpace returns the number of values of a sequence starting at the offset
+// up to the largest number of the width, or of int64 if it is zero.
+func seqSpace(offset, step int64, width int) *big.Int {
+	max := big.NewInt(math.MaxInt64)
+
+	if width > 0 {
+		pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(width)), nil)
+		if pow.Sub(pow, big.NewInt(1)).Cmp(max) < 0 {
+			max = pow
+		}
+	}
+
+	n := max.Sub(max, big.NewInt(offset))
+	if n.Sign() < 0 {
+		return n.SetInt64(0)
+	}
+
+	n.Div(n, big.NewInt(step))
+
+	return n.Add(n, big.NewInt(1))
+}
+
+func (g *SeqGen) format(n int64) string {
+	return fmt.Sprintf("%s%0*d", g.Prefix, g.Width, n)
+}
+
+// next reserves the next n values of the sequence in one increment.
+func (g *SeqGen) next(n int) ([]int64, error) {
+	last, err := g.store.NextSeq(g.def, int64(n)*g.Step)
+	if err != nil {
+		return nil, err
+	}
+
+	nums := make([]int64, n)
+	first := last - int64(n-1)*g.Step
+
+	for i := range nums {
+		nums[i] = first + int64(i)*g.Step
+	}
+
+	return nums, nil
 }
 
 // New generates a new sequential alias.
 func (g *SeqGen) New() (string, error) {
-	id, err := redis.Int64(g.conn.Do("INCR", seqPrefix+g.Name))
-	if err != nil && err != redis.ErrNil {
+	id, err := g.store.NextSeq(g.def, g.Step)
+	if err != nil {
 		return "", err
 	}
-	return strconv.FormatInt(id, 10), nil
+	return g.format(id), nil
+}
+
+// NewN generates n sequential aliases by reserving the range in one increment.
+func (g *SeqGen) NewN(n int) ([]string, error) {
+	nums, err := g.next(n)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := make([]string, n)
+
+	for i, num := range nums {
+		aliases[i] = g.format(num)
+	}
+
+	return aliases, nil
+}
+
+// HMACGen derives aliases from the HMAC-SHA256 of the ident keyed by a
+// secret, so the same ident always produces the same alias given the same
+// secret and settings. The digest is mapped onto the chars without modulo
+// bias. If an alias is taken, the next attempt is one char longer and begins
+// with the previous alias.
+type HMACGen struct {
+	Key []byte
+
+	// Name of the definition, so definitions sharing the key derive
+	// different aliases for the same ident and cannot be linked.
+	Name string
+
+	Prefix string
+	Minlen int
+	Chars  string
+}
+
+// New is not supported since the alias is derived from the ident.
+func (g *HMACGen) New() (string, error) {
+	return "", errors.New("hmac aliases require an ident")
+}
+
+// NewFor derives the alias of the ident.
+func (g *HMACGen) NewFor(ident string, attempt int) (string, error) {
+	var (
+		n     = len(g.Chars)
+		limit = 256 - 256%n
+		key   = make([]byte, 0, g.Minlen+attempt)
+		ctr   [4]byte
+		name  [4]byte
+	)
+
+	// The name is length-prefixed so it cannot run into the ident.
+	binary.BigEndian.PutUint32(name[:], uint32(len(g.Name)))
+
+	// The digest of each block is keyed by a counter so the stream can be
+	// extended for long aliases. Bytes that would bias the choice are skipped.
+	for block := uint32(0); len(key) < cap(key); block++ {
+		binary.BigEndian.PutUint32(ctr[:], block)
+
+		mac := hmac.New(sha256.New, g.Key)
+		mac.Write(ctr[:])
+		mac.Write(name[:])
+		mac.Write([]byte(g.Name))
+		mac.Write([]byte(ident))
+
+		for _, b := range mac.Sum(nil) {
+			if int(b) >= limit {
+				continue
+			}
+
+			key = append(key, g.Chars[int(b)%n])
+
+			if len(key) == cap(key) {
+				break
+			}
+		}
+	}
+
+	return g.Prefix + string(key), nil
 }
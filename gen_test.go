@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSeqGen(t *testing.T) {
+	st := NewMemoryStore()
+
+	def := NewDef()
+	def.Name = "seq"
+	def.Type = "seq"
+	def.Offset = 100
+	def.Step = 5
+	def.Width = 6
+	def.Prefix = "SUBJ-"
+
+	if err := st.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := MakeGen(st, nil, def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alias, err := g.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if alias != "SUBJ-000100" {
+		t.Errorf("expected SUBJ-000100, got %s", alias)
+	}
+
+	aliases, err := genN(g, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"SUBJ-000105", "SUBJ-000110", "SUBJ-000115"}
+	if !reflect.DeepEqual(aliases, exp) {
+		t.Errorf("expected %v, got %v", exp, aliases)
+	}
+}
+
+func TestSeqGenDefaults(t *testing.T) {
+	st := NewMemoryStore()
+
+	// Definitions created before the step was supported.
+	def := &Def{Name: "seq", Type: "seq"}
+
+	if err := st.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := MakeGen(st, nil, def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliases, err := genN(g, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"0", "1", "2"}
+	if !reflect.DeepEqual(aliases, exp) {
+		t.Errorf("expected %v, got %v", exp, aliases)
+	}
+}
+
+func TestHMACGen(t *testing.T) {
+	g := &HMACGen{
+		Key:    bytes.Repeat([]byte{1}, 32),
+		Prefix: "P-",
+		Minlen: 8,
+		Chars:  RandChars,
+	}
+
+	a, err := g.NewFor("1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a) != 10 || !strings.HasPrefix(a, "P-") {
+		t.Errorf("unexpected alias %s", a)
+	}
+
+	// Deterministic.
+	if b, _ := g.NewFor("1", 0); b != a {
+		t.Errorf("expected %s, got %s", a, b)
+	}
+
+	if b, _ := g.NewFor("2", 0); b == a {
+		t.Error("expected different idents to have different aliases")
+	}
+
+	// Later attempts extend the alias.
+	b, _ := g.NewFor("1", 1)
+	if len(b) != 11 || !strings.HasPrefix(b, a) {
+		t.Errorf("expected %s to extend %s", b, a)
+	}
+
+	// Long aliases span multiple digest blocks.
+	c, _ := g.NewFor("1", 100)
+	if len(c) != 110 || !strings.HasPrefix(c, b) {
+		t.Errorf("expected %s to extend %s", c, b)
+	}
+
+	g.Name = "other"
+
+	if b, _ := g.NewFor("1", 0); b == a {
+		t.Error("expected different defs to have different aliases")
+	}
+
+	g.Name = ""
+	g.Key = bytes.Repeat([]byte{2}, 32)
+
+	if b, _ := g.NewFor("1", 0); b == a {
+		t.Error("expected different keys to have different aliases")
+	}
+
+	if _, err := g.New(); err == nil {
+		t.Error("expected error without ident")
+	}
+}
+
+func TestHashidGen(t *testing.T) {
+	g, err := NewHashidGen(nil, "abcdefghijklmnopqrstuvwxyz0123456789", []byte("salt"), 6, "H-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	var prev string
+
+	for _, n := range []int64{0, 1, 2, 3, 35, 36, 37, 1000, 1 << 40, math.MaxInt64} {
+		alias := g.Encode(n)
+
+		if !strings.HasPrefix(alias, "H-") || len(alias) < 8 {
+			t.Errorf("unexpected alias %s for %d", alias, n)
+		}
+
+		if seen[alias] {
+			t.Errorf("duplicate alias %s", alias)
+		}
+		seen[alias] = true
+
+		// Consecutive numbers do not share the leading chars.
+		if prev != "" && alias[:4] == prev[:4] {
+			t.Errorf("expected %s and %s to differ", prev, alias)
+		}
+		prev = alias
+
+		m, err := g.Decode(alias)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m != n {
+			t.Errorf("expected %s to decode to %d, got %d", alias, n, m)
+		}
+	}
+
+	// A different salt produces different aliases.
+	g2, _ := NewHashidGen(nil, "abcdefghijklmnopqrstuvwxyz0123456789", []byte("other"), 6, "H-")
+	if g2.Encode(1000) == g.Encode(1000) {
+		t.Error("expected salts to produce different aliases")
+	}
+
+	for _, alias := range []string{"", "H-", "H-a", "X-abcdef", "H-abc!ef", g.Encode(5) + "a", "H-zzzzzzzzzzzzzzzzzzzz"} {
+		if _, err := g.Decode(alias); err != ErrBadHashid {
+			t.Errorf("expected %q to be invalid, got %v", alias, err)
+		}
+	}
+
+	if _, err := NewHashidGen(nil, "abcdefgha", nil, 0, ""); err == nil {
+		t.Error("expected error for duplicate chars")
+	}
+}
+
+func TestHashidGenPadding(t *testing.T) {
+	g, err := NewHashidGen(nil, "abcdefghijklmnopqrstuvwxyz0123456789", []byte("salt"), 8, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var codes []string
+
+	for _, n := range []int64{1, 2, 37} {
+		code := g.Encode(n)
+
+		if len(code) != 8 {
+			t.Errorf("expected 8 chars, got %s", code)
+		}
+
+		// No run of a pad char.
+		for i := 2; i < len(code); i++ {
+			if code[i] == code[i-1] && code[i] == code[i-2] {
+				t.Errorf("unexpected run in %s", code)
+			}
+		}
+
+		if m, err := g.Decode(code); err != nil || m != n {
+			t.Errorf("expected %s to decode to %d, got %d (%v)", code, n, m, err)
+		}
+
+		codes = append(codes, code)
+	}
+
+	// The guards are picked by the number, not only the code.
+	a := g.pad([]byte("abc"), 0, []byte(g.alphabet))
+	b := g.pad([]byte("abc"), 1, []byte(g.alphabet))
+
+	if a[0] == b[0] {
+		t.Errorf("expected the guards of %s and %s to differ", a, b)
+	}
+
+	// The padding is not shared between codes.
+	for i, a := range codes {
+		for _, b := range codes[i+1:] {
+			for k := 0; k+3 <= len(a); k++ {
+				if strings.Contains(b, a[k:k+3]) {
+					t.Errorf("%s and %s share %s", a, b, a[k:k+3])
+				}
+			}
+		}
+	}
+}
+
+func TestUUIDv5Gen(t *testing.T) {
+	ns, err := parseNamespace("dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := &UUIDv5Gen{Namespace: ns}
+
+	alias, err := g.NewFor("python.org", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if alias != "886313e1-3b8a-5372-9b90-0c9aee199e5d" {
+		t.Errorf("unexpected alias %s", alias)
+	}
+
+	if _, err := g.NewFor("python.org", 1); err == nil {
+		t.Error("expected error for taken alias")
+	}
+
+	if _, err := parseNamespace("not-a-uuid"); err == nil {
+		t.Error("expected error for bad namespace")
+	}
+}
+
+func TestWordsGen(t *testing.T) {
+	words := make(map[string]bool, len(wordList))
+	for _, w := range wordList {
+		words[w] = true
+	}
+
+	if len(words) != 1296 {
+		t.Fatalf("expected 1296 unique words, got %d", len(words))
+	}
+
+	g := newWordsGen(&Def{Prefix: "W:", Words: 2, Separator: "_", Digits: 2})
+	g.Source = newCryptoSource(crand.Reader)
+
+	if e := g.Entropy(); math.Abs(e-(2*math.Log2(1296)+2*math.Log2(10))) > 1e-9 {
+		t.Errorf("unexpected entropy %f", e)
+	}
+
+	for i := 0; i < 100; i++ {
+		alias, err := g.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parts := strings.Split(strings.TrimPrefix(alias, "W:"), "_")
+
+		if len(parts) != 3 || !words[parts[0]] || !words[parts[1]] || len(parts[2]) != 2 {
+			t.Fatalf("unexpected alias %s", alias)
+		}
+
+		if strings.Trim(parts[2], digitChars) != "" {
+			t.Fatalf("unexpected suffix in %s", alias)
+		}
+	}
+
+	// Defaults.
+	g = newWordsGen(&Def{})
+	g.Source = newCryptoSource(crand.Reader)
+
+	alias, _ := g.New()
+
+	if parts := strings.Split(alias, "-"); len(parts) != 3 {
+		t.Errorf("unexpected alias %s", alias)
+	}
+}
+
+func TestSeqSpace(t *testing.T) {
+	tests := []struct {
+		offset, step int64
+		width        int
+		space        string
+	}{
+		{0, 1, 3, "1000"},
+		{1, 1, 3, "999"},
+		{100, 5, 3, "180"},
+		{1000, 1, 3, "0"},
+		{1, 1, 0, "9223372036854775807"},
+		{1, 1, MaxSeqWidth, "9223372036854775807"},
+	}
+
+	for _, test := range tests {
+		if s := seqSpace(test.offset, test.step, test.width).String(); s != test.space {
+			t.Errorf("%+v: expected %s, got %s", test, test.space, s)
+		}
+	}
+}
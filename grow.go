@@ -0,0 +1,145 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+var (
+	// DefaultGrowRate is the default collision rate of generated rand aliases
+	// above which the min length of the definition is increased. Growth is
+	// disabled by default, since it changes the stored definition.
+	DefaultGrowRate = 0.0
+	// GrowSamples is the number of candidates the collision rate is measured
+	// over.
+	GrowSamples = 1000
+	// MaxRandMinlen is the length rand aliases will not grow beyond.
+	MaxRandMinlen = 64
+
+	// Number of times the min length of each definition was increased.
+	minlenGrowths = expvar.NewMap("aliases_minlen_growths")
+)
+
+// collisionStats counts the candidates of a definition that were taken since
+// its min length last changed.
+type collisionStats struct {
+	candidates int
+	collisions int
+}
+
+// growable returns true if the min length of the definition can be
+// increased automatically.
+func (s *Server) growable(def *Def) bool {
+	return s.GrowRate > 0 && def.Type == "rand" && def.Minlen < MaxRandMinlen
+}
+
+// recordCollisions adds the number of candidates and the number of those that
+// were taken. It returns true if the collision rate over at least GrowSamples
+// candidates exceeds the grow rate.
+func (s *Server) recordCollisions(def *Def, candidates, collisions int) bool {
+	if !s.growable(def) {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.collisions == nil {
+		s.collisions = make(map[int]*collisionStats)
+	}
+
+	st, ok := s.collisions[def.ID]
+	if !ok {
+		st = &collisionStats{}
+		s.collisions[def.ID] = st
+	}
+
+	st.candidates += candidates
+	st.collisions += collisions
+
+	if st.candidates < GrowSamples {
+		return false
+	}
+
+	rate := float64(st.collisions) / float64(st.candidates)
+
+	// Start a new sample.
+	*st = collisionStats{}
+
+	return rate > s.GrowRate
+}
+
+// growLock returns the lock serializing the growth of the min length of the
+// definition, so growing one definition does not block the others.
+func (s *Server) growLock(def *Def) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.growLocks == nil {
+		s.growLocks = make(map[int]*sync.Mutex)
+	}
+
+	l, ok := s.growLocks[def.ID]
+	if !ok {
+		l = &sync.Mutex{}
+		s.growLocks[def.ID] = l
+	}
+
+	return l
+}
+
+// growMinlen increases the min length of the definition by one and stores it.
+// If another request already increased it, the definition is updated to the
+// stored length instead.
+func (s *Server) growMinlen(def *Def, reason string) error {
+	l := s.growLock(def)
+	l.Lock()
+	defer l.Unlock()
+
+	cur, err := s.Store.GetDefByID(def.ID)
+	if err != nil {
+		return err
+	}
+
+	if cur.Deleted {
+		return ErrNoDef
+	}
+
+	if cur.Minlen > def.Minlen {
+		def.Minlen = cur.Minlen
+		return nil
+	}
+
+	if cur.Minlen >= MaxRandMinlen {
+		return fmt.Errorf("min length of '%s' cannot grow beyond %d", def.Name, MaxRandMinlen)
+	}
+
+	cur.Minlen++
+
+	if err := s.Store.UpdateDef(cur.Name, cur); err != nil {
+		return err
+	}
+
+	def.Minlen = cur.Minlen
+
+	s.mu.Lock()
+	delete(s.collisions, def.ID)
+	s.mu.Unlock()
+
+	minlenGrowths.Add(def.Name, 1)
+
+	s.Log.Printf("grew min length of '%s' to %d: %s", def.Name, def.Minlen, reason)
+
+	return nil
+}
+
+// growGen grows the min length of the definition and returns its new
+// generator.
+func (s *Server) growGen(def *Def, reason string) (Gen, error) {
+	if err := s.growMinlen(def, reason); err != nil {
+		return nil, err
+	}
+
+	return MakeGen(s.Store, s.Keys, def)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ErrBadHashid is returned when decoding a string that was not encoded by
+// the generator.
+var ErrBadHashid = errors.New("invalid hashid")
+
+// shuffle permutes the alphabet in place, consistently for the same salt.
+// This is the shuffle used by Hashids.
+func shuffle(alphabet, salt []byte) {
+	if len(salt) == 0 {
+		return
+	}
+
+	for i, v, p := len(alphabet)-1, 0, 0; i > 0; i-- {
+		v %= len(salt)
+		n := int(salt[v])
+		p += n
+		j := (n + v + p) % i
+		alphabet[i], alphabet[j] = alphabet[j], alphabet[i]
+		v++
+	}
+}
+
+// HashidGen encodes the sequence of the definition into short strings that
+// do not reveal the order or count of the aliases. The first char, the
+// lottery, is picked by the number and reshuffles the salted alphabet the
+// rest is encoded in, so consecutive numbers have unrelated aliases. Codes
+// shorter than Minlen are wrapped in guard chars, which are reserved from the
+// alphabet, and then in filler chars shuffled by the code, so the padding
+// does not reveal the magnitude of the number.
+type HashidGen struct {
+	Prefix string
+	Minlen int
+
+	alphabet string
+	guards   string
+	salt     []byte
+	seq      *SeqGen
+}
+
+// hashidGuardDiv is the ratio of alphabet chars to guard chars.
+const hashidGuardDiv = 12
+
+// NewHashidGen returns a generator encoding the values of the sequence with
+// the chars shuffled by the salt.
+func NewHashidGen(seq *SeqGen, chars string, salt []byte, minlen int, prefix string) (*HashidGen, error) {
+	if len(chars) < 3 {
+		return nil, errors.New("at least 3 chars required")
+	}
+
+	seen := make(map[byte]struct{}, len(chars))
+
+	for i := 0; i < len(chars); i++ {
+		if _, ok := seen[chars[i]]; ok {
+			return nil, fmt.Errorf("duplicate char '%c'", chars[i])
+		}
+		seen[chars[i]] = struct{}{}
+	}
+
+	alphabet := []byte(chars)
+	shuffle(alphabet, salt)
+
+	guards := len(alphabet) / hashidGuardDiv
+	if guards == 0 {
+		guards = 1
+	}
+
+	return &HashidGen{
+		Prefix:   prefix,
+		Minlen:   minlen,
+		alphabet: string(alphabet[guards:]),
+		guards:   string(alphabet[:guards]),
+		salt:     salt,
+		seq:      seq,
+	}, nil
+}
+
+// lottery returns the alphabet the number is encoded in after the lottery
+// char.
+func (g *HashidGen) lottery(c byte) []byte {
+	alphabet := []byte(g.alphabet)
+	shuffle(alphabet, append([]byte{c}, g.salt...))
+	return alphabet
+}
+
+// Encode encodes a non-negative number.
+func (g *HashidGen) Encode(n int64) string {
+	var (
+		num     = n
+		base    = int64(len(g.alphabet))
+		lottery = g.alphabet[n%base]
+		digits  = g.lottery(lottery)
+		body    []byte
+	)
+
+	for {
+		body = append(body, digits[n%base])
+		n /= base
+
+		if n == 0 {
+			break
+		}
+	}
+
+	code := make([]byte, 0, 1+len(body))
+	code = append(code, lottery)
+
+	for i := len(body) - 1; i >= 0; i-- {
+		code = append(code, body[i])
+	}
+
+	return g.Prefix + string(g.pad(code, num, digits))
+}
+
+// pad wraps the code in a guard on each side and then in filler chars until
+// it is Minlen chars long. The guards are picked by the number and the code,
+// and the filler is the alphabet of the code shuffled by the code, so codes
+// of similar numbers are padded differently.
+func (g *HashidGen) pad(code []byte, n int64, digits []byte) []byte {
+	guards := int64(len(g.guards))
+
+	if len(code) < g.Minlen {
+		guard := g.guards[(n%guards+int64(code[0]))%guards]
+		code = append([]byte{guard}, code...)
+	}
+
+	if len(code) < g.Minlen {
+		guard := g.guards[(n%guards+int64(code[2]))%guards]
+		code = append(code, guard)
+	}
+
+	if len(code) >= g.Minlen {
+		return code
+	}
+
+	filler := append([]byte(nil), digits...)
+	shuffle(filler, code)
+
+	half := len(filler) / 2
+
+	for len(code) < g.Minlen {
+		shuffle(filler, append([]byte(nil), filler...))
+
+		padded := make([]byte, 0, len(filler)+len(code))
+		padded = append(padded, filler[half:]...)
+		padded = append(padded, code...)
+		padded = append(padded, filler[:half]...)
+
+		if excess := len(padded) - g.Minlen; excess > 0 {
+			start := excess / 2
+			padded = padded[start : start+g.Minlen]
+		}
+
+		code = padded
+	}
+
+	return code
+}
+
+// Decode decodes the number from the alias.
+func (g *HashidGen) Decode(alias string) (int64, error) {
+	if !strings.HasPrefix(alias, g.Prefix) {
+		return 0, ErrBadHashid
+	}
+
+	code := alias[len(g.Prefix):]
+
+	// The code is between the guards of padded aliases.
+	if i := strings.IndexAny(code, g.guards); i >= 0 {
+		code = code[i+1:]
+
+		if j := strings.IndexAny(code, g.guards); j >= 0 {
+			code = code[:j]
+		}
+	}
+
+	if len(code) < 2 {
+		return 0, ErrBadHashid
+	}
+
+	var (
+		base   = int64(len(g.alphabet))
+		digits = string(g.lottery(code[0]))
+		n      int64
+	)
+
+	for i := 1; i < len(code); i++ {
+		d := strings.IndexByte(digits, code[i])
+		if d < 0 || n > (math.MaxInt64-int64(d))/base {
+			return 0, ErrBadHashid
+		}
+
+		n = n*base + int64(d)
+	}
+
+	// Only the canonical encoding is valid.
+	if g.Encode(n) != alias {
+		return 0, ErrBadHashid
+	}
+
+	return n, nil
+}
+
+// Space returns the number of values of the sequence. The width does not
+// apply to hashids.
+func (g *HashidGen) Space() *big.Int {
+	if g.seq == nil {
+		return nil
+	}
+
+	return seqSpace(g.seq.Offset, g.seq.Step, 0)
+}
+
+// New generates a new alias from the next value of the sequence.
+func (g *HashidGen) New() (string, error) {
+	aliases, err := g.NewN(1)
+	if err != nil {
+		return "", err
+	}
+	return aliases[0], nil
+}
+
+// NewN generates n aliases by reserving the range of the sequence in one
+// increment.
+func (g *HashidGen) NewN(n int) ([]string, error) {
+	nums, err := g.seq.next(n)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := make([]string, n)
+
+	for i, num := range nums {
+		aliases[i] = g.Encode(num)
+	}
+
+	return aliases, nil
+}
+
+// Reverse decodes the value of the sequence from the alias.
+func (g *HashidGen) Reverse(alias string) (string, error) {
+	n, err := g.Decode(alias)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(n, 10), nil
+}
@@ -2,18 +2,26 @@ package main
 
 import (
 	"bufio"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 const applicationJSON = "application/json"
 
+// Prefix of the expvar names served by the metrics endpoint.
+const metricsPrefix = "aliases_"
+
 func makeCreateDefHandler(s *Server) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		defer r.Body.Close()
@@ -71,9 +79,47 @@ func makeUpdateDefHandler(s *Server) httprouter.Handle {
 	}
 }
 
+func parseDefsQuery(v url.Values) (DefsQuery, error) {
+	var (
+		q   DefsQuery
+		err error
+	)
+
+	switch v.Get("archived") {
+	case "":
+	case "1", "true":
+		q.State = DefsArchived
+	case "0", "false":
+		q.State = DefsActive
+	default:
+		return q, errors.New("archived must be true or false")
+	}
+
+	if o := v.Get("offset"); o != "" {
+		if q.Offset, err = strconv.Atoi(o); err != nil || q.Offset < 0 {
+			return q, errors.New("offset must be a non-negative integer")
+		}
+	}
+
+	if l := v.Get("limit"); l != "" {
+		if q.Limit, err = strconv.Atoi(l); err != nil || q.Limit < 0 {
+			return q, errors.New("limit must be a non-negative integer")
+		}
+	}
+
+	return q, nil
+}
+
 func makeGetDefsHandler(s *Server) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-		defs, err := s.GetDefs()
+		q, err := parseDefsQuery(r.URL.Query())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		defs, total, err := s.GetDefs(q)
 		if err != nil {
 			w.WriteHeader(http.StatusServiceUnavailable)
 			fmt.Fprint(w, err.Error())
@@ -81,6 +127,7 @@ func makeGetDefsHandler(s *Server) httprouter.Handle {
 		}
 
 		w.Header().Set("content-type", applicationJSON)
+		w.Header().Set("x-total-count", strconv.Itoa(total))
 
 		if err := json.NewEncoder(w).Encode(defs); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -110,6 +157,42 @@ func makeDeleteDefHandler(s *Server) httprouter.Handle {
 	}
 }
 
+func makeRestoreDefHandler(s *Server) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		name := p.ByName("name")
+
+		var id int
+
+		if v := r.URL.Query().Get("id"); v != "" {
+			var err error
+			if id, err = strconv.Atoi(v); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, "id must be an integer")
+				return
+			}
+		}
+
+		def, err := s.RestoreDef(name, id)
+		switch err {
+		case nil:
+		case ErrNoDef:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case ErrDefExists, ErrDefNotArchived, ErrPurged:
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, err.Error())
+			return
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		w.Header().Set("content-type", applicationJSON)
+		json.NewEncoder(w).Encode(def)
+	}
+}
+
 func makeGetDefHandler(s *Server) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		name := p.ByName("name")
@@ -171,6 +254,57 @@ func parseGenBody(mediaType string, r io.Reader) ([]*IdentAlias, error) {
 	return idents, nil
 }
 
+// parseAliasBody parses a JSON array or newline delimited set of aliases.
+func parseAliasBody(mediaType string, r io.Reader) ([]string, error) {
+	var aliases []string
+
+	if mediaType == applicationJSON {
+		err := json.NewDecoder(r).Decode(&aliases)
+		return aliases, err
+	}
+
+	sc := bufio.NewScanner(r)
+
+	for sc.Scan() {
+		aliases = append(aliases, sc.Text())
+	}
+
+	return aliases, sc.Err()
+}
+
+// parseReverseBody reads the reason and aliases of a reverse lookup. JSON
+// bodies are an object with the reason and aliases, and other bodies have the
+// reason on the first line followed by an alias per line. The reason is not
+// read from the URL so it is not written to access logs.
+func parseReverseBody(mediaType string, r io.Reader) (string, []string, error) {
+	if mediaType == applicationJSON {
+		var body struct {
+			Reason  string   `json:"reason"`
+			Aliases []string `json:"aliases"`
+		}
+
+		err := json.NewDecoder(r).Decode(&body)
+		return body.Reason, body.Aliases, err
+	}
+
+	sc := bufio.NewScanner(r)
+
+	var (
+		reason  string
+		aliases []string
+	)
+
+	if sc.Scan() {
+		reason = sc.Text()
+	}
+
+	for sc.Scan() {
+		aliases = append(aliases, sc.Text())
+	}
+
+	return reason, aliases, sc.Err()
+}
+
 func makeGenHandler(s *Server) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		name := p.ByName("name")
@@ -229,6 +363,12 @@ func makeGenHandler(s *Server) httprouter.Handle {
 		}
 
 		idents, err = s.Gen(def, idents)
+		if _, ok := err.(*IdentError); ok {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
 		if err != nil {
 			w.WriteHeader(http.StatusServiceUnavailable)
 			fmt.Fprint(w, err.Error())
@@ -319,7 +459,11 @@ func makePutHandler(s *Server) httprouter.Handle {
 			return
 		}
 
-		if err := s.Put(def, idents); err != nil {
+		if err := s.Put(def, idents); err == ErrAliasExists {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, err.Error())
+			return
+		} else if err != nil {
 			w.WriteHeader(http.StatusServiceUnavailable)
 			fmt.Fprint(w, err.Error())
 			return
@@ -387,3 +531,310 @@ func makeDeleteHandler(s *Server) httprouter.Handle {
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+func makeValidateHandler(s *Server) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		name := p.ByName("name")
+
+		def, err := s.GetDef(name)
+		if err == ErrNoDef {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Something else wrong.
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("content-type"))
+
+		aliases, err := parseAliasBody(mediaType, r.Body)
+
+		r.Body.Close()
+
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		valid, err := s.Validate(def, aliases)
+		if err == ErrNoCheck {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		switch mediaType {
+		case applicationJSON:
+			type result struct {
+				Alias string `json:"alias"`
+				Valid bool   `json:"valid"`
+			}
+
+			results := make([]result, len(aliases))
+			for i, alias := range aliases {
+				results[i] = result{alias, valid[i]}
+			}
+
+			w.Header().Set("content-type", applicationJSON)
+			json.NewEncoder(w).Encode(results)
+
+		default:
+			for _, ok := range valid {
+				if ok {
+					fmt.Fprintln(w, "1")
+				} else {
+					fmt.Fprintln(w, "0")
+				}
+			}
+		}
+	}
+}
+
+// requireToken only serves requests with the bearer token. If the token is
+// not set, the endpoint is disabled.
+func requireToken(token string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if token == "" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "endpoint is disabled")
+			return
+		}
+
+		auth := []byte(r.Header.Get("authorization"))
+
+		if subtle.ConstantTimeCompare(auth, []byte("Bearer "+token)) != 1 {
+			w.Header().Set("www-authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		h(w, r, p)
+	}
+}
+
+func makeDecodeHandler(s *Server) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		name := p.ByName("name")
+
+		def, err := s.GetDef(name)
+		if err == ErrNoDef {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Something else wrong.
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("content-type"))
+
+		aliases, err := parseAliasBody(mediaType, r.Body)
+
+		r.Body.Close()
+
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		idents, err := s.Decode(def, aliases)
+		if err == ErrNotReversible || err == ErrDecodeIdents {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		switch mediaType {
+		case applicationJSON:
+			w.Header().Set("content-type", applicationJSON)
+			json.NewEncoder(w).Encode(idents)
+
+		default:
+			for _, ia := range idents {
+				switch ia.Status {
+				case StatusExists:
+					fmt.Fprintln(w, "1", ia.Ident)
+				case StatusMissing:
+					fmt.Fprintln(w, "0")
+				}
+			}
+		}
+	}
+}
+
+func makeReverseHandler(s *Server) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		name := p.ByName("name")
+
+		def, err := s.GetDef(name)
+		if err == ErrNoDef {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Something else wrong.
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("content-type"))
+
+		reason, aliases, err := parseReverseBody(mediaType, r.Body)
+
+		r.Body.Close()
+
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		idents, err := s.Reverse(def, aliases, reason, r.RemoteAddr)
+
+		switch err {
+		case nil:
+		case ErrNoReverseIndex, ErrNoReason:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err.Error())
+			return
+		case ErrNoAudit:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, err.Error())
+			return
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		switch mediaType {
+		case applicationJSON:
+			w.Header().Set("content-type", applicationJSON)
+			json.NewEncoder(w).Encode(idents)
+
+		default:
+			for _, ia := range idents {
+				switch ia.Status {
+				case StatusExists:
+					fmt.Fprintln(w, "1", ia.Ident)
+				case StatusMissing:
+					fmt.Fprintln(w, "0")
+				}
+			}
+		}
+	}
+}
+
+// makeMetricsHandler writes the aliases_* vars in expvar format. Other vars,
+// such as cmdline which holds the tokens and passwords passed as flags, are
+// not exposed.
+func makeMetricsHandler() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.Header().Set("content-type", applicationJSON)
+
+		fmt.Fprint(w, "{")
+
+		first := true
+
+		expvar.Do(func(kv expvar.KeyValue) {
+			if !strings.HasPrefix(kv.Key, metricsPrefix) {
+				return
+			}
+
+			if !first {
+				fmt.Fprint(w, ",")
+			}
+			first = false
+
+			fmt.Fprintf(w, "\n%q: %s", kv.Key, kv.Value)
+		})
+
+		fmt.Fprint(w, "\n}\n")
+	}
+}
+
+func makeGetDefStatsHandler(s *Server) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		def, err := s.GetDef(p.ByName("name"))
+		if err == ErrNoDef {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		st, err := s.Stats(def)
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+
+		w.Header().Set("content-type", applicationJSON)
+		json.NewEncoder(w).Encode(st)
+	}
+}
+
+func makeGetPurgesHandler(s *Server) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		if s.Purger == nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "purging is disabled")
+			return
+		}
+
+		w.Header().Set("content-type", applicationJSON)
+		json.NewEncoder(w).Encode(s.Purger.Status())
+	}
+}
+
+func makeGetPurgeHandler(s *Server) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if s.Purger == nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "purging is disabled")
+			return
+		}
+
+		id, err := strconv.Atoi(p.ByName("id"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "id must be an integer")
+			return
+		}
+
+		st, ok := s.Purger.StatusOf(id)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("content-type", applicationJSON)
+		json.NewEncoder(w).Encode(st)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestRequireToken(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	tests := []struct {
+		token string
+		auth  string
+		code  int
+	}{
+		{"", "", http.StatusForbidden},
+		{"", "Bearer ", http.StatusForbidden},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer other", http.StatusUnauthorized},
+		{"secret", "secret", http.StatusUnauthorized},
+		{"secret", "Bearer secret", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/keys/test/decode", nil)
+		if test.auth != "" {
+			r.Header.Set("authorization", test.auth)
+		}
+
+		w := httptest.NewRecorder()
+		requireToken(test.token, ok)(w, r, nil)
+
+		if w.Code != test.code {
+			t.Errorf("token %q, auth %q: expected %d, got %d", test.token, test.auth, test.code, w.Code)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	minlenGrowths.Add("metrics", 1)
+
+	w := httptest.NewRecorder()
+	makeMetricsHandler()(w, httptest.NewRequest("GET", "/debug/vars", nil), nil)
+
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := vars["aliases_minlen_growths"]; !ok {
+		t.Error("expected aliases vars")
+	}
+
+	for name := range vars {
+		if !strings.HasPrefix(name, metricsPrefix) {
+			t.Errorf("unexpected var %s", name)
+		}
+	}
+}
+
+// conflictStore rejects explicitly set aliases like a store enforcing unique
+// aliases.
+type conflictStore struct {
+	Store
+}
+
+func (s *conflictStore) Set(def *Def, idents []*IdentAlias) error {
+	return ErrAliasExists
+}
+
+func TestPutHandlerConflict(t *testing.T) {
+	s := initServer(t)
+	s.Store = &conflictStore{Store: s.Store}
+
+	def := NewDef()
+	def.Name = "test"
+	def.Type = "rand"
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("PUT", "/keys/test", strings.NewReader("a x1\n"))
+	w := httptest.NewRecorder()
+
+	makePutHandler(s)(w, r, httprouter.Params{{Key: "name", Value: "test"}})
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected %d, got %d", http.StatusConflict, w.Code)
+	}
+}
+
+func TestParseReverseBody(t *testing.T) {
+	reason, aliases, err := parseReverseBody("text/plain", strings.NewReader("irb-42\nx1\nx2\n"))
+	if err != nil || reason != "irb-42" || len(aliases) != 2 || aliases[1] != "x2" {
+		t.Errorf("unexpected %q %v (%v)", reason, aliases, err)
+	}
+
+	reason, aliases, err = parseReverseBody(applicationJSON, strings.NewReader(`{"reason": "irb-42", "aliases": ["x1"]}`))
+	if err != nil || reason != "irb-42" || len(aliases) != 1 || aliases[0] != "x1" {
+		t.Errorf("unexpected %q %v (%v)", reason, aliases, err)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// hashedIdentPrefix marks idents replaced by their HMAC, so a migration that
+// was interrupted can be resumed without hashing them twice.
+const hashedIdentPrefix = "h:"
+
+// hashIdent returns the keyed HMAC of the ident that is stored in its place.
+func hashIdent(key []byte, ident string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ident))
+
+	return hashedIdentPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// isHashedIdent returns true if the stored ident is an HMAC.
+func isHashedIdent(ident string) bool {
+	if !strings.HasPrefix(ident, hashedIdentPrefix) {
+		return false
+	}
+
+	b, err := hex.DecodeString(ident[len(hashedIdentPrefix):])
+
+	return err == nil && len(b) == sha256.Size
+}
+
+// hashStore replaces idents with their HMAC before passing them to the store
+// and restores them afterwards, so the store never holds the raw idents.
+type hashStore struct {
+	Store
+	key []byte
+}
+
+// hash replaces the idents and returns a function restoring them.
+func (s *hashStore) hash(idents []*IdentAlias) func() {
+	raw := make([]string, len(idents))
+
+	for i, ia := range idents {
+		raw[i] = ia.Ident
+		ia.Ident = hashIdent(s.key, ia.Ident)
+	}
+
+	return func() {
+		for i, ia := range idents {
+			ia.Ident = raw[i]
+		}
+	}
+}
+
+func (s *hashStore) Lookup(def *Def, idents []*IdentAlias) error {
+	defer s.hash(idents)()
+	return s.Store.Lookup(def, idents)
+}
+
+func (s *hashStore) Claim(def *Def, idents []*IdentAlias) error {
+	defer s.hash(idents)()
+	return s.Store.Claim(def, idents)
+}
+
+func (s *hashStore) Set(def *Def, idents []*IdentAlias) error {
+	defer s.hash(idents)()
+	return s.Store.Set(def, idents)
+}
+
+func (s *hashStore) Del(def *Def, idents []string) (int, error) {
+	hashed := make([]string, len(idents))
+
+	for i, ident := range idents {
+		hashed[i] = hashIdent(s.key, ident)
+	}
+
+	return s.Store.Del(def, hashed)
+}
+
+// identStore returns the store idents of the definition are passed to, which
+// hashes them if the definition has an ident key.
+func (s *Server) identStore(def *Def) (Store, error) {
+	if def.Migrating {
+		return nil, ErrMigrating
+	}
+
+	if def.IdentKey == "" {
+		return s.Store, nil
+	}
+
+	key, err := s.Keys.Get(def.IdentKey)
+	if err != nil {
+		return nil, fmt.Errorf("unknown ident key '%s'", def.IdentKey)
+	}
+
+	return &hashStore{Store: s.Store, key: key}, nil
+}
+
+// MigrateIdents replaces the stored idents of an existing definition with
+// their HMAC under the keyring secret and sets its ident key. The aliases are
+// kept. The definition is marked as migrating before any ident is replaced,
+// so idents cannot be passed to it until the migration is done. If it is
+// interrupted, running it again skips the idents that were already replaced.
+func (s *Server) MigrateIdents(def *Def, keyName string) (int, error) {
+	if def.IdentKey != "" && !def.Migrating {
+		return 0, fmt.Errorf("idents of '%s' are already hashed", def.Name)
+	}
+
+	if def.Migrating && def.IdentKey != keyName {
+		return 0, fmt.Errorf("idents of '%s' are being hashed with '%s'", def.Name, def.IdentKey)
+	}
+
+	if def.Reverse {
+		return 0, fmt.Errorf("idents of '%s' cannot be hashed, it has a reverse index", def.Name)
+	}
+
+	key, err := s.Keys.Get(keyName)
+	if err != nil {
+		return 0, fmt.Errorf("unknown ident key '%s'", keyName)
+	}
+
+	if !def.Migrating {
+		all, err := s.Store.Idents(def)
+		if err != nil {
+			return 0, err
+		}
+
+		// Replaced idents are told apart by their form when resuming, so no
+		// raw ident may have it.
+		for _, ident := range all {
+			if isHashedIdent(ident) {
+				return 0, fmt.Errorf("idents of '%s' cannot be hashed, an ident has the form of a hashed ident", def.Name)
+			}
+		}
+
+		def.IdentKey = keyName
+		def.Migrating = true
+
+		if err := s.Store.UpdateDef(def.Name, def); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+
+	// Idents set by requests that began before the definition was marked
+	// are replaced by the next pass.
+	for {
+		all, err := s.Store.Idents(def)
+		if err != nil {
+			return 0, err
+		}
+
+		var from, to []string
+
+		for _, ident := range all {
+			if isHashedIdent(ident) {
+				continue
+			}
+
+			from = append(from, ident)
+			to = append(to, hashIdent(key, ident))
+		}
+
+		if len(from) == 0 {
+			break
+		}
+
+		err = chunks(len(from), func(i, j int) error {
+			return s.Store.RenameIdents(def, from[i:j], to[i:j])
+		})
+
+		if err != nil {
+			return 0, err
+		}
+
+		n += len(from)
+	}
+
+	def.Migrating = false
+
+	if err := s.Store.UpdateDef(def.Name, def); err != nil {
+		return 0, err
+	}
+
+	s.Log.Printf("hashed %d idents of '%s'", n, def.Name)
+
+	return n, nil
+}
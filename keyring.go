@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrNoKey is returned when a definition refers to a secret that is not
+	// in the keyring.
+	ErrNoKey = errors.New("no key")
+
+	// MinKeyLen is the minimum length in bytes of a keyring secret.
+	MinKeyLen = 16
+)
+
+// Keyring holds the named secrets used by keyed generators. The secrets are
+// kept outside of the store so a definition only refers to one by name.
+type Keyring map[string][]byte
+
+// Get returns the secret by name or ErrNoKey.
+func (k Keyring) Get(name string) ([]byte, error) {
+	key, ok := k[name]
+	if !ok {
+		return nil, ErrNoKey
+	}
+
+	return key, nil
+}
+
+// LoadKeyring reads a keyring file. Each line is a name and a hex-encoded
+// secret separated by an equals sign, e.g. site=<hex>. Blank lines and lines
+// starting with # are ignored.
+func LoadKeyring(path string) (Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := make(Keyring)
+	scanner := bufio.NewScanner(f)
+
+	var n int
+
+	for scanner.Scan() {
+		n++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		toks := strings.SplitN(line, "=", 2)
+		if len(toks) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected name=secret", path, n)
+		}
+
+		name := strings.TrimSpace(toks[0])
+		if !nameRegex.MatchString(name) {
+			return nil, fmt.Errorf("%s:%d: bad key name", path, n)
+		}
+
+		if _, ok := keys[name]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate key '%s'", path, n, name)
+		}
+
+		key, err := hex.DecodeString(strings.TrimSpace(toks[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: secret must be hex-encoded", path, n)
+		}
+
+		if len(key) < MinKeyLen {
+			return nil, fmt.Errorf("%s:%d: secret must be at least %d bytes", path, n, MinKeyLen)
+		}
+
+		keys[name] = key
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
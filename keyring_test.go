@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeKeyring(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "keys")
+
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadKeyring(t *testing.T) {
+	path := writeKeyring(t, `
+# Site secrets.
+a = 000102030405060708090a0b0c0d0e0f
+b=ffffffffffffffffffffffffffffffffffffffff
+`)
+
+	keys, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+
+	key, err := keys.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(key, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}) {
+		t.Errorf("unexpected key %x", key)
+	}
+
+	if _, err := keys.Get("c"); err != ErrNoKey {
+		t.Errorf("expected ErrNoKey, got %v", err)
+	}
+}
+
+func TestLoadKeyringInvalid(t *testing.T) {
+	bad := []string{
+		"a",
+		"a=zz",
+		"a=0001",
+		"a b=000102030405060708090a0b0c0d0e0f",
+		"a=000102030405060708090a0b0c0d0e0f\na=000102030405060708090a0b0c0d0e0f",
+	}
+
+	for _, data := range bad {
+		if _, err := LoadKeyring(writeKeyring(t, data)); err == nil {
+			t.Errorf("expected error for %q", data)
+		}
+	}
+}
@@ -5,12 +5,34 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 var buildVersion string
 
+// openStore opens the store described by spec. The Redis store is configured
+// from the server options, so nil is returned for it.
+func openStore(spec string) (Store, error) {
+	switch {
+	case spec == "redis":
+		return nil, nil
+
+	case spec == "memory":
+		return NewMemoryStore(), nil
+
+	case strings.HasPrefix(spec, "bolt:"):
+		return NewBoltStore(strings.TrimPrefix(spec, "bolt:"))
+
+	case strings.HasPrefix(spec, "postgres://"), strings.HasPrefix(spec, "postgresql://"):
+		return NewPostgresStore(spec)
+	}
+
+	return nil, fmt.Errorf("unknown store '%s'", spec)
+}
+
 func main() {
 	var (
 		redisAddr string
@@ -18,6 +40,22 @@ func main() {
 		redisPass string
 		redisTLS  bool
 
+		storeSpec string
+		keysFile  string
+
+		identKey      string
+		migrateIdents string
+
+		purgeGrace    time.Duration
+		purgeInterval time.Duration
+
+		decodeToken string
+
+		reverseToken string
+		reverseAudit string
+
+		growRate float64
+
 		httpAddr    string
 		httpTLSKey  string
 		httpTLSCert string
@@ -30,6 +68,23 @@ func main() {
 	flag.StringVar(&redisPass, "redis.pass", "", "Redis password.")
 	flag.BoolVar(&redisTLS, "redis.tls", false, "Redis TLS connection.")
 
+	flag.StringVar(&storeSpec, "store", "redis", "Store backend: redis, memory, bolt:<path>, or postgres://<dsn>.")
+
+	flag.StringVar(&keysFile, "keys", "", "Keyring file of secrets for keyed generators.")
+
+	flag.StringVar(&identKey, "ident.key", "", "Keyring secret the idents of new definitions are hashed with before they are stored.")
+	flag.StringVar(&migrateIdents, "migrate.idents", "", "Comma-separated definitions whose stored idents are hashed with ident.key, then exit.")
+
+	flag.DurationVar(&purgeGrace, "purge.grace", 0, "Time after archiving before a definition's aliases are purged. Zero disables purging.")
+	flag.DurationVar(&purgeInterval, "purge.interval", DefaultPurgeInterval, "How often archived definitions are checked for purging.")
+
+	flag.Float64Var(&growRate, "grow.rate", DefaultGrowRate, "Collision rate of rand aliases above which their min length is increased. Zero disables growth.")
+
+	flag.StringVar(&decodeToken, "decode.token", "", "Bearer token required to decode aliases. Decoding is disabled if not set.")
+
+	flag.StringVar(&reverseToken, "reverse.token", "", "Bearer token required to look up the identifiers of aliases. Reverse lookups are disabled if not set.")
+	flag.StringVar(&reverseAudit, "reverse.audit", "", "Audit log file reverse lookups are recorded in. Required with reverse.token.")
+
 	flag.StringVar(&httpAddr, "http", "127.0.0.1:8080", "HTTP bind address.")
 	flag.StringVar(&httpTLSKey, "http.tls.key", "", "TLS key file.")
 	flag.StringVar(&httpTLSCert, "http.tls.cert", "", "TLS certificate file.")
@@ -45,14 +100,83 @@ func main() {
 
 	var s Server
 
+	store, err := openStore(storeSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if reverseToken != "" {
+		if reverseAudit == "" {
+			log.Fatal("reverse.audit is required with reverse.token")
+		}
+
+		if reverseToken == decodeToken {
+			log.Fatal("reverse.token must differ from decode.token")
+		}
+
+		audit, err := OpenAuditLog(reverseAudit)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		s.Audit = audit
+	}
+
+	if keysFile != "" {
+		keys, err := LoadKeyring(keysFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		s.Keys = keys
+	}
+
+	s.Store = store
 	s.RedisAddr = redisAddr
 	s.RedisDB = redisDB
 	s.RedisPass = redisPass
 	s.RedisTLS = redisTLS
+	s.GrowRate = growRate
+	s.IdentKey = identKey
 	s.Init()
 
 	defer s.Close()
 
+	if identKey != "" {
+		if _, err := s.Keys.Get(identKey); err != nil {
+			log.Fatalf("unknown ident key '%s'", identKey)
+		}
+	}
+
+	if migrateIdents != "" {
+		if identKey == "" {
+			log.Fatal("ident.key is required with migrate.idents")
+		}
+
+		for _, name := range strings.Split(migrateIdents, ",") {
+			def, err := s.GetDef(strings.TrimSpace(name))
+			if err != nil {
+				log.Fatalf("%s: %s", name, err)
+			}
+
+			if _, err := s.MigrateIdents(def, identKey); err != nil {
+				log.Fatalf("%s: %s", name, err)
+			}
+		}
+
+		return
+	}
+
+	if purgeGrace > 0 {
+		s.Purger = NewPurger(s.Store, purgeGrace, s.Log)
+		s.Purger.Interval = purgeInterval
+
+		stop := make(chan struct{})
+		defer close(stop)
+
+		go s.Purger.Run(stop)
+	}
+
 	mux := httprouter.New()
 
 	mux.GET("/defs", makeGetDefsHandler(&s))
@@ -61,10 +185,20 @@ func main() {
 	mux.GET("/defs/:name", makeGetDefHandler(&s))
 	mux.PUT("/defs/:name", makeUpdateDefHandler(&s))
 	mux.DELETE("/defs/:name", makeDeleteDefHandler(&s))
+	mux.POST("/defs/:name/restore", makeRestoreDefHandler(&s))
+	mux.GET("/defs/:name/stats", makeGetDefStatsHandler(&s))
+
+	mux.GET("/debug/vars", makeMetricsHandler())
+
+	mux.GET("/purges", makeGetPurgesHandler(&s))
+	mux.GET("/purges/:id", makeGetPurgeHandler(&s))
 
 	mux.POST("/keys/:name", makeGenHandler(&s))
 	mux.PUT("/keys/:name", makePutHandler(&s))
 	mux.DELETE("/keys/:name", makeDeleteHandler(&s))
+	mux.POST("/keys/:name/validate", makeValidateHandler(&s))
+	mux.POST("/keys/:name/decode", requireToken(decodeToken, makeDecodeHandler(&s)))
+	mux.POST("/keys/:name/reverse", requireToken(reverseToken, makeReverseHandler(&s)))
 
 	log.Printf("HTTP listening on %s", httpAddr)
 	if httpTLSKey != "" {
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemoryStore is a Store that holds all state in memory. It is safe for
+// concurrent use and is intended for tests and embedding the service
+// in-process.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	lastID int
+	names  map[string]int
+	values map[int][]byte
+
+	keys    map[int]map[string]string
+	aliases map[int]map[string]string
+	seqs    map[int]int64
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		names:   make(map[string]int),
+		values:  make(map[int][]byte),
+		keys:    make(map[int]map[string]string),
+		aliases: make(map[int]map[string]string),
+		seqs:    make(map[int]int64),
+	}
+}
+
+// Close is a no-op.
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) setValue(def *Def) error {
+	b, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+
+	s.values[def.ID] = b
+	return nil
+}
+
+func (s *MemoryStore) getValue(id int) (*Def, error) {
+	var def Def
+	if err := json.Unmarshal(s.values[id], &def); err != nil {
+		return nil, err
+	}
+
+	return &def, nil
+}
+
+// CreateDef creates a new definition.
+func (s *MemoryStore) CreateDef(def *Def) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.names[def.Name]; ok {
+		return ErrDefExists
+	}
+
+	s.lastID++
+	def.ID = s.lastID
+
+	if err := s.setValue(def); err != nil {
+		return err
+	}
+
+	s.names[def.Name] = def.ID
+
+	// Initialize the sequence.
+	if def.Sequential() {
+		s.seqs[def.ID] = def.seqInit()
+	}
+
+	return nil
+}
+
+// GetDef retrieves an existing definition.
+func (s *MemoryStore) GetDef(name string) (*Def, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.names[name]
+	if !ok {
+		return nil, ErrNoDef
+	}
+
+	return s.getValue(id)
+}
+
+// GetDefByID retrieves a definition by ID.
+func (s *MemoryStore) GetDefByID(id int) (*Def, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.values[id]; !ok {
+		return nil, ErrNoDef
+	}
+
+	return s.getValue(id)
+}
+
+// GetDefs retrieves the page of definitions ordered by ID.
+func (s *MemoryStore) GetDefs(q DefsQuery) ([]*Def, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int, 0, len(s.values))
+	for id := range s.values {
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+
+	defs := make([]*Def, len(ids))
+
+	for i, id := range ids {
+		def, err := s.getValue(id)
+		if err != nil {
+			return nil, 0, err
+		}
+		defs[i] = def
+	}
+
+	defs, total := pageDefs(defs, q)
+
+	return defs, total, nil
+}
+
+// UpdateDef updates an existing definition.
+func (s *MemoryStore) UpdateDef(name string, def *Def) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.setValue(def); err != nil {
+		return err
+	}
+
+	delete(s.names, name)
+	s.names[def.Name] = def.ID
+
+	return nil
+}
+
+// DelDef deletes the name entry and updates the definition.
+func (s *MemoryStore) DelDef(def *Def) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.setValue(def); err != nil {
+		return err
+	}
+
+	delete(s.names, def.Name)
+
+	return nil
+}
+
+// RestoreDef sets the name entry unless it is taken and updates the
+// definition.
+func (s *MemoryStore) RestoreDef(def *Def) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.values[def.ID]; !ok {
+		return ErrNoDef
+	}
+
+	if _, ok := s.names[def.Name]; ok {
+		return ErrDefExists
+	}
+
+	if err := s.setValue(def); err != nil {
+		return err
+	}
+
+	s.names[def.Name] = def.ID
+
+	return nil
+}
+
+// Lookup gets the aliases of the idents.
+func (s *MemoryStore) Lookup(def *Def, idents []*IdentAlias) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ia := range idents {
+		alias, ok := s.keys[def.ID][ia.Ident]
+		if !ok {
+			ia.Status = StatusMissing
+			continue
+		}
+
+		ia.Alias = alias
+		ia.Status = StatusExists
+	}
+
+	return nil
+}
+
+// Claim assigns the aliases to the idents if neither is taken.
+func (s *MemoryStore) Claim(def *Def, idents []*IdentAlias) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ia := range idents {
+		if cur, ok := s.keys[def.ID][ia.Ident]; ok {
+			ia.Alias = cur
+			ia.Status = StatusExists
+			continue
+		}
+
+		if _, ok := s.aliases[def.ID][ia.Alias]; ok {
+			ia.Alias = ""
+			ia.Status = 0
+			continue
+		}
+
+		s.set(def, ia.Ident, ia.Alias)
+		ia.Status = StatusCreated
+	}
+
+	return nil
+}
+
+// set sets the key and alias entries. The alias entry holds the ident if the
+// definition has a reverse index.
+func (s *MemoryStore) set(def *Def, ident, alias string) {
+	id := def.ID
+
+	if s.keys[id] == nil {
+		s.keys[id] = make(map[string]string)
+		s.aliases[id] = make(map[string]string)
+	}
+
+	s.keys[id][ident] = alias
+
+	if def.Reverse {
+		s.aliases[id][alias] = ident
+	} else {
+		s.aliases[id][alias] = ""
+	}
+}
+
+// Set sets the key and alias entries of the idents.
+func (s *MemoryStore) Set(def *Def, idents []*IdentAlias) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ia := range idents {
+		s.set(def, ia.Ident, ia.Alias)
+	}
+
+	return nil
+}
+
+// Reverse gets the idents of the aliases.
+func (s *MemoryStore) Reverse(def *Def, aliases []*IdentAlias) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ia := range aliases {
+		ident := s.aliases[def.ID][ia.Alias]
+		if ident == "" {
+			ia.Status = StatusMissing
+			continue
+		}
+
+		ia.Ident = ident
+		ia.Status = StatusExists
+	}
+
+	return nil
+}
+
+// Del deletes the key and alias entries of the idents.
+func (s *MemoryStore) Del(def *Def, idents []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+
+	for _, ident := range idents {
+		alias, ok := s.keys[def.ID][ident]
+		if !ok {
+			continue
+		}
+
+		delete(s.keys[def.ID], ident)
+		delete(s.aliases[def.ID], alias)
+		n++
+	}
+
+	return n, nil
+}
+
+// Idents returns all idents of the definition.
+func (s *MemoryStore) Idents(def *Def) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idents := make([]string, 0, len(s.keys[def.ID]))
+
+	for ident := range s.keys[def.ID] {
+		idents = append(idents, ident)
+	}
+
+	return idents, nil
+}
+
+// RenameIdents replaces the key entries of the idents.
+func (s *MemoryStore) RenameIdents(def *Def, from, to []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := s.keys[def.ID]
+
+	for i, ident := range from {
+		alias, ok := keys[ident]
+		if !ok {
+			continue
+		}
+
+		if _, ok := keys[to[i]]; ok {
+			return fmt.Errorf("ident %s exists", to[i])
+		}
+
+		delete(keys, ident)
+		keys[to[i]] = alias
+	}
+
+	return nil
+}
+
+// Count returns the number of aliases of the definition.
+func (s *MemoryStore) Count(def *Def) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.aliases[def.ID]), nil
+}
+
+// NextSeq increments the sequence of the definition.
+func (s *MemoryStore) NextSeq(def *Def, n int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seqs[def.ID] += n
+	return s.seqs[def.ID], nil
+}
+
+// Purge deletes up to n key and alias entries of the definition.
+func (s *MemoryStore) Purge(def *Def, n int) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed int
+
+	for ident, alias := range s.keys[def.ID] {
+		if removed == n {
+			return removed, false, nil
+		}
+
+		delete(s.keys[def.ID], ident)
+		delete(s.aliases[def.ID], alias)
+		removed++
+	}
+
+	delete(s.keys, def.ID)
+	delete(s.aliases, def.ID)
+	delete(s.seqs, def.ID)
+	delete(s.values, def.ID)
+
+	return removed, true, nil
+}
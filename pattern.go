@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// MaxPatternRepeat is the maximum repetition count of a pattern element.
+	MaxPatternRepeat = 64
+	// MaxPatternLen is the maximum length of aliases generated by a pattern.
+	MaxPatternLen = 128
+
+	// MinPatternSpace is the minimum number of aliases a pattern without a
+	// sequence must be able to generate.
+	MinPatternSpace = big.NewInt(4096)
+
+	upperChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+// patternPart is an element of a compiled pattern. Exactly one of the fields
+// is set, except for the sequence whose width may be zero.
+type patternPart struct {
+	// Random char from the class.
+	class string
+	// Literal string.
+	lit string
+	// Zero-padded value of the sequence.
+	seq      bool
+	seqWidth int
+	// Current date in the format.
+	date string
+}
+
+// ParsePattern compiles a pattern. The elements are:
+//
+//	#          a digit
+//	@          an uppercase letter
+//	*          a char of chars
+//	[A-Z0-9]   a char of the class, which may contain ranges
+//	{n}        repeats the previous element n times
+//	{seq}      the next value of the sequence, {seq:n} zero-pads it to n digits
+//	{date:f}   the current UTC date where YYYY, YY, MM, and DD in f are replaced
+//	\c         the literal char c
+//
+// Any other char is a literal, e.g. AB-####-@@.
+func ParsePattern(pattern, chars string) ([]patternPart, error) {
+	var parts []patternPart
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+
+		switch c {
+		case '\\':
+			i++
+			if i == len(pattern) {
+				return nil, errors.New("pattern ends with an escape")
+			}
+			parts = append(parts, patternPart{lit: pattern[i : i+1]})
+
+		case '#':
+			parts = append(parts, patternPart{class: digitChars})
+
+		case '@':
+			parts = append(parts, patternPart{class: upperChars})
+
+		case '*':
+			if chars == "" {
+				return nil, errors.New("pattern uses * without chars")
+			}
+			parts = append(parts, patternPart{class: chars})
+
+		case '[':
+			j := strings.IndexByte(pattern[i:], ']')
+			if j < 0 {
+				return nil, errors.New("unterminated class in pattern")
+			}
+
+			class, err := parseClass(pattern[i+1 : i+j])
+			if err != nil {
+				return nil, err
+			}
+
+			parts = append(parts, patternPart{class: class})
+			i += j
+
+		case '{':
+			j := strings.IndexByte(pattern[i:], '}')
+			if j < 0 {
+				return nil, errors.New("unterminated braces in pattern")
+			}
+
+			expr := pattern[i+1 : i+j]
+			i += j
+
+			switch {
+			case expr == "seq":
+				parts = append(parts, patternPart{seq: true})
+
+			case strings.HasPrefix(expr, "seq:"):
+				w, err := strconv.Atoi(expr[4:])
+				if err != nil || w < 1 || w > MaxSeqWidth {
+					return nil, fmt.Errorf("seq width must be between 1 and %d", MaxSeqWidth)
+				}
+				parts = append(parts, patternPart{seq: true, seqWidth: w})
+
+			case strings.HasPrefix(expr, "date:"):
+				if expr[5:] == "" {
+					return nil, errors.New("date format required")
+				}
+				parts = append(parts, patternPart{date: expr[5:]})
+
+			default:
+				n, err := strconv.Atoi(expr)
+				if err != nil {
+					return nil, fmt.Errorf("unknown pattern element '{%s}'", expr)
+				}
+
+				if n < 1 || n > MaxPatternRepeat {
+					return nil, fmt.Errorf("repeat count must be between 1 and %d", MaxPatternRepeat)
+				}
+
+				if len(parts) == 0 {
+					return nil, errors.New("nothing to repeat in pattern")
+				}
+
+				prev := parts[len(parts)-1]
+				if prev.class == "" && prev.lit == "" {
+					return nil, errors.New("only chars can be repeated in pattern")
+				}
+
+				for k := 1; k < n; k++ {
+					parts = append(parts, prev)
+				}
+			}
+
+		default:
+			parts = append(parts, patternPart{lit: pattern[i : i+1]})
+		}
+	}
+
+	if len(parts) == 0 {
+		return nil, errors.New("empty pattern")
+	}
+
+	if len(parts) > MaxPatternLen {
+		return nil, fmt.Errorf("pattern may generate at most %d chars", MaxPatternLen)
+	}
+
+	return parts, nil
+}
+
+// parseClass expands the ranges of a class and removes duplicate chars.
+func parseClass(s string) (string, error) {
+	var (
+		b    []byte
+		seen = make(map[byte]bool)
+	)
+
+	add := func(c byte) {
+		if !seen[c] {
+			seen[c] = true
+			b = append(b, c)
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		if i+2 < len(s) && s[i+1] == '-' {
+			if s[i] > s[i+2] {
+				return "", fmt.Errorf("bad range '%s' in class", s[i:i+3])
+			}
+
+			for c := int(s[i]); c <= int(s[i+2]); c++ {
+				add(byte(c))
+			}
+
+			i += 2
+			continue
+		}
+
+		add(s[i])
+	}
+
+	if len(b) == 0 {
+		return "", errors.New("empty class in pattern")
+	}
+
+	return string(b), nil
+}
+
+// formatDate replaces the YYYY, YY, MM, and DD tokens of the format.
+func formatDate(format string, t time.Time) string {
+	r := strings.NewReplacer(
+		"YYYY", fmt.Sprintf("%04d", t.Year()),
+		"YY", fmt.Sprintf("%02d", t.Year()%100),
+		"MM", fmt.Sprintf("%02d", t.Month()),
+		"DD", fmt.Sprintf("%02d", t.Day()),
+	)
+
+	return r.Replace(format)
+}
+
+// PatternGen generates aliases from a pattern.
+type PatternGen struct {
+	Prefix string
+	Now    func() time.Time
+	Source Source
+
+	parts []patternPart
+	seq   *SeqGen
+}
+
+// Space returns the number of aliases the random and sequence elements can
+// generate, or nil if a sequence is not zero-padded and so is unbounded.
+// Dates are not counted since they change over time.
+func (g *PatternGen) Space() *big.Int {
+	space := big.NewInt(1)
+
+	for _, p := range g.parts {
+		switch {
+		case p.class != "":
+			space.Mul(space, big.NewInt(int64(len(p.class))))
+
+		case p.seq:
+			if p.seqWidth == 0 {
+				return nil
+			}
+
+			space.Mul(space, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(p.seqWidth)), nil))
+		}
+	}
+
+	return space
+}
+
+// Entropy returns the number of random bits of an alias.
+func (g *PatternGen) Entropy() float64 {
+	var e float64
+
+	for _, p := range g.parts {
+		if p.class != "" {
+			e += math.Log2(float64(len(p.class)))
+		}
+	}
+
+	return e
+}
+
+// hasSeq returns true if the pattern contains a sequence element.
+func (g *PatternGen) hasSeq() bool {
+	for _, p := range g.parts {
+		if p.seq {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g *PatternGen) format(num int64, now time.Time) (string, error) {
+	var b strings.Builder
+
+	b.WriteString(g.Prefix)
+
+	for _, p := range g.parts {
+		switch {
+		case p.class != "":
+			j, err := g.Source.Intn(len(p.class))
+			if err != nil {
+				return "", err
+			}
+			b.WriteByte(p.class[j])
+
+		case p.lit != "":
+			b.WriteString(p.lit)
+
+		case p.seq:
+			fmt.Fprintf(&b, "%0*d", p.seqWidth, num)
+
+		case p.date != "":
+			b.WriteString(formatDate(p.date, now))
+		}
+	}
+
+	return b.String(), nil
+}
+
+// New generates a new alias.
+func (g *PatternGen) New() (string, error) {
+	aliases, err := g.NewN(1)
+	if err != nil {
+		return "", err
+	}
+	return aliases[0], nil
+}
+
+// NewN generates n aliases, reserving the values of the sequence in one
+// increment if the pattern contains one.
+func (g *PatternGen) NewN(n int) ([]string, error) {
+	nums := make([]int64, n)
+
+	if g.hasSeq() {
+		var err error
+		if nums, err = g.seq.next(n); err != nil {
+			return nil, err
+		}
+	}
+
+	now := g.Now().UTC()
+	aliases := make([]string, n)
+
+	for i, num := range nums {
+		alias, err := g.format(num, now)
+		if err != nil {
+			return nil, err
+		}
+		aliases[i] = alias
+	}
+
+	return aliases, nil
+}
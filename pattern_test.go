@@ -0,0 +1,128 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"math/big"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestParsePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		chars   string
+		re      string
+		space   int64
+	}{
+		{"AB-####-@@", "", `^AB-\d{4}-[A-Z]{2}$`, 10000 * 26 * 26},
+		{"#{6}", "", `^\d{6}$`, 1000000},
+		{"[A-C]{2}[xyz]", "", `^[A-C]{2}[xyz]$`, 27},
+		{"*{3}", "ab", `^[ab]{3}$`, 8},
+		{`\#\@\\-#`, "", `^#@\\-\d$`, 10},
+		{"S{seq:4}-#", "", `^S\d{4}-\d$`, 100000},
+		{"{date:YYYYMMDD}-##", "", `^\d{8}-\d{2}$`, 100},
+		{"[aab]", "", `^[ab]$`, 2},
+	}
+
+	for _, test := range tests {
+		parts, err := ParsePattern(test.pattern, test.chars)
+		if err != nil {
+			t.Fatalf("%s: %s", test.pattern, err)
+		}
+
+		st := NewMemoryStore()
+		def := &Def{Name: "p", Type: "pattern", Step: 1}
+
+		if err := st.CreateDef(def); err != nil {
+			t.Fatal(err)
+		}
+
+		g := &PatternGen{
+			Now:    time.Now,
+			Source: newCryptoSource(crand.Reader),
+			parts:  parts,
+			seq:    newSeqGen(st, def),
+		}
+
+		if space := g.Space(); space.Cmp(big.NewInt(test.space)) != 0 {
+			t.Errorf("%s: expected space %d, got %s", test.pattern, test.space, space)
+		}
+
+		re := regexp.MustCompile(test.re)
+
+		for i := 0; i < 20; i++ {
+			alias, err := g.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !re.MatchString(alias) {
+				t.Errorf("%s: unexpected alias %s", test.pattern, alias)
+			}
+		}
+	}
+}
+
+func TestParsePatternInvalid(t *testing.T) {
+	bad := []string{
+		"",
+		`ab\`,
+		"[abc",
+		"[]",
+		"[z-a]",
+		"#{",
+		"#{0}",
+		"#{65}",
+		"{3}",
+		"{seq}{2}",
+		"{seq:0}",
+		"{seq:20}",
+		"{date:}",
+		"{foo}",
+		"*",
+		"#{64}#{64}#",
+	}
+
+	for _, pattern := range bad {
+		if _, err := ParsePattern(pattern, ""); err == nil {
+			t.Errorf("expected error for %q", pattern)
+		}
+	}
+}
+
+func TestPatternGenSeqDate(t *testing.T) {
+	st := NewMemoryStore()
+
+	def := NewDef()
+	def.Name = "p"
+	def.Type = "pattern"
+	def.Pattern = "{date:YY-MM-DD}/{seq:3}"
+	def.Offset = 10
+
+	if err := st.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := MakeGen(st, nil, def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g.(*PatternGen).Now = func() time.Time {
+		return time.Date(2024, 3, 7, 12, 0, 0, 0, time.UTC)
+	}
+
+	if space := g.(SpaceGen).Space(); space.Int64() != 1000 {
+		t.Errorf("expected space of 1000, got %s", space)
+	}
+
+	aliases, err := genN(g, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if aliases[0] != "24-03-07/010" || aliases[1] != "24-03-07/011" {
+		t.Errorf("unexpected aliases %v", aliases)
+	}
+}
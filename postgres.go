@@ -0,0 +1,555 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+// Unique violation error code.
+const pqUniqueViolation = "23505"
+
+// Schema of the Postgres store. The name of archived definitions is cleared
+// so it can be reused, mirroring the removal of the d:<name> key in Redis.
+// Uniqueness of idents and aliases within a definition is enforced by the
+// constraints rather than by checking before setting.
+var postgresSchema = []string{
+	`create table if not exists alias_defs (
+		id serial primary key,
+		name text unique,
+		value text not null,
+		seq bigint not null default 0
+	)`,
+
+	`create table if not exists alias_keys (
+		def_id integer not null references alias_defs (id),
+		ident text not null,
+		alias text not null,
+		created timestamptz not null default now(),
+		primary key (def_id, ident),
+		unique (def_id, alias)
+	)`,
+}
+
+// PostgresStore is a Store backed by a PostgreSQL database.
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+// NewPostgresStore connects to the database and creates the schema if it does
+// not exist.
+func NewPostgresStore(dsn string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stmt := range postgresSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &PostgresStore{DB: db}, nil
+}
+
+func isUniqueViolation(err error) bool {
+	e, ok := err.(*pq.Error)
+	return ok && e.Code == pqUniqueViolation
+}
+
+// Close closes the database.
+func (s *PostgresStore) Close() error {
+	return s.DB.Close()
+}
+
+func scanDef(row interface {
+	Scan(...interface{}) error
+}) (*Def, error) {
+	var blob []byte
+	if err := row.Scan(&blob); err != nil {
+		return nil, err
+	}
+
+	var def Def
+	if err := json.Unmarshal(blob, &def); err != nil {
+		return nil, err
+	}
+
+	return &def, nil
+}
+
+// CreateDef creates a new definition.
+func (s *PostgresStore) CreateDef(def *Def) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Initialize the sequence. The value is set once the ID is known.
+	err = tx.QueryRow(`
+		insert into alias_defs (name, value, seq) values ($1, '', $2)
+		on conflict (name) do nothing
+		returning id
+	`, def.Name, def.seqInit()).Scan(&def.ID)
+
+	// Cannot create a def by the same name.
+	if err == sql.ErrNoRows {
+		return ErrDefExists
+	} else if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`update alias_defs set value = $2 where id = $1`, def.ID, string(b)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetDef retrieves an existing definition.
+func (s *PostgresStore) GetDef(name string) (*Def, error) {
+	def, err := scanDef(s.DB.QueryRow(`select value from alias_defs where name = $1`, name))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoDef
+	}
+
+	return def, err
+}
+
+// GetDefByID retrieves a definition by ID.
+func (s *PostgresStore) GetDefByID(id int) (*Def, error) {
+	def, err := scanDef(s.DB.QueryRow(`select value from alias_defs where id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoDef
+	}
+
+	return def, err
+}
+
+// GetDefs retrieves the page of definitions ordered by ID. Archived
+// definitions have no name.
+func (s *PostgresStore) GetDefs(q DefsQuery) ([]*Def, int, error) {
+	var where string
+
+	switch q.State {
+	case DefsActive:
+		where = `where name is not null`
+	case DefsArchived:
+		where = `where name is null`
+	}
+
+	var total int
+
+	if err := s.DB.QueryRow(`select count(*) from alias_defs ` + where).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	// A null limit returns all rows.
+	var limit *int
+	if q.Limit > 0 {
+		limit = &q.Limit
+	}
+
+	rows, err := s.DB.Query(`select value from alias_defs `+where+` order by id offset $1 limit $2`, q.Offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	defs := []*Def{}
+
+	for rows.Next() {
+		def, err := scanDef(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		defs = append(defs, def)
+	}
+
+	return defs, total, rows.Err()
+}
+
+// UpdateDef updates an existing definition. ErrDefExists is returned if it
+// is renamed to the name of another definition.
+func (s *PostgresStore) UpdateDef(name string, def *Def) error {
+	b, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.Exec(`update alias_defs set name = $2, value = $3 where id = $1`, def.ID, def.Name, string(b))
+	if isUniqueViolation(err) {
+		return ErrDefExists
+	}
+
+	return err
+}
+
+// DelDef clears the name and updates the definition.
+func (s *PostgresStore) DelDef(def *Def) error {
+	b, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.DB.Exec(`update alias_defs set name = null, value = $2 where id = $1`, def.ID, string(b))
+	return err
+}
+
+// RestoreDef sets the name unless it is taken and updates the definition.
+func (s *PostgresStore) RestoreDef(def *Def) error {
+	b, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.DB.Exec(`update alias_defs set name = $2, value = $3 where id = $1`, def.ID, def.Name, string(b))
+	if isUniqueViolation(err) {
+		return ErrDefExists
+	} else if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		return ErrNoDef
+	}
+
+	return err
+}
+
+// Lookup gets the aliases of the idents with a single query.
+func (s *PostgresStore) Lookup(def *Def, idents []*IdentAlias) error {
+	if len(idents) == 0 {
+		return nil
+	}
+
+	args := make([]string, len(idents))
+	for i, ia := range idents {
+		args[i] = ia.Ident
+	}
+
+	rows, err := s.DB.Query(`
+		select ident, alias from alias_keys where def_id = $1 and ident = any($2)
+	`, def.ID, pq.Array(args))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	aliases := make(map[string]string, len(idents))
+
+	for rows.Next() {
+		var ident, alias string
+		if err := rows.Scan(&ident, &alias); err != nil {
+			return err
+		}
+
+		aliases[ident] = alias
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, ia := range idents {
+		alias, ok := aliases[ia.Ident]
+		if !ok {
+			ia.Status = StatusMissing
+			continue
+		}
+
+		ia.Alias = alias
+		ia.Status = StatusExists
+	}
+
+	return nil
+}
+
+// Claim inserts the idents and aliases in one transaction. If either
+// conflicts, the ident is looked up to determine which one is taken.
+func (s *PostgresStore) Claim(def *Def, idents []*IdentAlias) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare(`
+		insert into alias_keys (def_id, ident, alias) values ($1, $2, $3)
+		on conflict do nothing
+	`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	lookup, err := tx.Prepare(`
+		select alias from alias_keys where def_id = $1 and ident = $2
+	`)
+	if err != nil {
+		return err
+	}
+	defer lookup.Close()
+
+	// Results are applied after the commit so a failed transaction leaves
+	// the idents untouched.
+	aliases := make([]string, len(idents))
+	statuses := make([]Status, len(idents))
+
+	for _, i := range insertOrder(idents) {
+		ia := idents[i]
+
+		res, err := insert.Exec(def.ID, ia.Ident, ia.Alias)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if n == 1 {
+			aliases[i] = ia.Alias
+			statuses[i] = StatusCreated
+			continue
+		}
+
+		err = lookup.QueryRow(def.ID, ia.Ident).Scan(&aliases[i])
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		statuses[i] = StatusExists
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for i, ia := range idents {
+		ia.Alias = aliases[i]
+		ia.Status = statuses[i]
+	}
+
+	return nil
+}
+
+// insertOrder returns the indexes of the idents sorted by ident and alias.
+// Rows are inserted in this order so concurrent transactions with overlapping
+// batches wait on each other for the same row first instead of deadlocking.
+func insertOrder(idents []*IdentAlias) []int {
+	order := make([]int, len(idents))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.Slice(order, func(a, b int) bool {
+		x, y := idents[order[a]], idents[order[b]]
+		if x.Ident != y.Ident {
+			return x.Ident < y.Ident
+		}
+		return x.Alias < y.Alias
+	})
+
+	return order
+}
+
+// Set sets the aliases of the idents in one transaction. ErrAliasExists is
+// returned if an alias is assigned to another ident.
+func (s *PostgresStore) Set(def *Def, idents []*IdentAlias) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		insert into alias_keys (def_id, ident, alias) values ($1, $2, $3)
+		on conflict (def_id, ident) do update set alias = excluded.alias
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, i := range insertOrder(idents) {
+		ia := idents[i]
+
+		_, err := stmt.Exec(def.ID, ia.Ident, ia.Alias)
+		if isUniqueViolation(err) {
+			return ErrAliasExists
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Del deletes the idents and their aliases.
+func (s *PostgresStore) Del(def *Def, idents []string) (int, error) {
+	if len(idents) == 0 {
+		return 0, nil
+	}
+
+	res, err := s.DB.Exec(`
+		delete from alias_keys where def_id = $1 and ident = any($2)
+	`, def.ID, pq.Array(idents))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Reverse gets the idents of the aliases. The idents are stored alongside
+// the aliases, so they are only returned if the definition has a reverse
+// index.
+func (s *PostgresStore) Reverse(def *Def, aliases []*IdentAlias) error {
+	for _, ia := range aliases {
+		ia.Status = StatusMissing
+	}
+
+	if len(aliases) == 0 || !def.Reverse {
+		return nil
+	}
+
+	args := make([]string, len(aliases))
+	for i, ia := range aliases {
+		args[i] = ia.Alias
+	}
+
+	rows, err := s.DB.Query(`
+		select alias, ident from alias_keys where def_id = $1 and alias = any($2)
+	`, def.ID, pq.Array(args))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	idents := make(map[string]string, len(aliases))
+
+	for rows.Next() {
+		var alias, ident string
+		if err := rows.Scan(&alias, &ident); err != nil {
+			return err
+		}
+
+		idents[alias] = ident
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, ia := range aliases {
+		if ident, ok := idents[ia.Alias]; ok {
+			ia.Ident = ident
+			ia.Status = StatusExists
+		}
+	}
+
+	return nil
+}
+
+// Idents returns all idents of the definition.
+func (s *PostgresStore) Idents(def *Def) ([]string, error) {
+	rows, err := s.DB.Query(`
+		select ident from alias_keys where def_id = $1
+	`, def.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var idents []string
+
+	for rows.Next() {
+		var ident string
+		if err := rows.Scan(&ident); err != nil {
+			return nil, err
+		}
+
+		idents = append(idents, ident)
+	}
+
+	return idents, rows.Err()
+}
+
+// RenameIdents replaces the idents in one statement. The primary key rejects
+// new idents that already exist.
+func (s *PostgresStore) RenameIdents(def *Def, from, to []string) error {
+	if len(from) == 0 {
+		return nil
+	}
+
+	_, err := s.DB.Exec(`
+		update alias_keys k set ident = m.new_ident
+		from unnest($2::text[], $3::text[]) as m (old_ident, new_ident)
+		where k.def_id = $1 and k.ident = m.old_ident
+	`, def.ID, pq.Array(from), pq.Array(to))
+
+	return err
+}
+
+// Count returns the number of aliases of the definition.
+func (s *PostgresStore) Count(def *Def) (int, error) {
+	var n int
+
+	err := s.DB.QueryRow(`
+		select count(*) from alias_keys where def_id = $1
+	`, def.ID).Scan(&n)
+
+	return n, err
+}
+
+// NextSeq increments the sequence of the definition.
+func (s *PostgresStore) NextSeq(def *Def, n int64) (int64, error) {
+	var last int64
+
+	err := s.DB.QueryRow(`
+		update alias_defs set seq = seq + $2 where id = $1 returning seq
+	`, def.ID, n).Scan(&last)
+
+	return last, err
+}
+
+// Purge deletes up to n idents of the definition. Once none are left the
+// definition is deleted.
+func (s *PostgresStore) Purge(def *Def, n int) (int, bool, error) {
+	res, err := s.DB.Exec(`
+		delete from alias_keys where ctid in (
+			select ctid from alias_keys where def_id = $1 limit $2
+		)
+	`, def.ID, n)
+	if err != nil {
+		return 0, false, err
+	}
+
+	removed, err := res.RowsAffected()
+	if err != nil || removed > 0 {
+		return int(removed), false, err
+	}
+
+	if _, err := s.DB.Exec(`delete from alias_defs where id = $1 and name is null`, def.ID); err != nil {
+		return 0, false, err
+	}
+
+	return 0, true, nil
+}
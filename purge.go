@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrPurged is returned when restoring a definition whose purge has
+	// started.
+	ErrPurged = errors.New("def purge has started")
+
+	// DefaultPurgeInterval is how often archived definitions are checked.
+	DefaultPurgeInterval = time.Minute
+	// PurgeBatchSize is the number of idents removed per purge step.
+	PurgeBatchSize = 1000
+)
+
+// Purge states.
+const (
+	PurgePending = "pending"
+	PurgeRunning = "running"
+	PurgeDone    = "done"
+	PurgeFailed  = "failed"
+)
+
+// PurgeStatus reports the progress of purging an archived definition.
+type PurgeStatus struct {
+	ID       int        `json:"id"`
+	Name     string     `json:"name"`
+	State    string     `json:"state"`
+	Due      *time.Time `json:"due,omitempty"`
+	Removed  int        `json:"removed"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+
+	// Time the purger first saw the definition.
+	seen time.Time
+}
+
+// Purger removes the idents and aliases of archived definitions once their
+// grace period has passed. The keys are removed in batches so the store is
+// not blocked.
+type Purger struct {
+	Store Store
+	Log   *log.Logger
+
+	// Grace is the time after archiving before a definition is purged.
+	Grace time.Duration
+	// Interval is how often archived definitions are checked.
+	Interval time.Duration
+
+	mu     sync.Mutex
+	status map[int]*PurgeStatus
+}
+
+// NewPurger returns a purger for the archived definitions in the store.
+func NewPurger(st Store, grace time.Duration, logger *log.Logger) *Purger {
+	return &Purger{
+		Store:    st,
+		Log:      logger,
+		Grace:    grace,
+		Interval: DefaultPurgeInterval,
+		status:   make(map[int]*PurgeStatus),
+	}
+}
+
+// Run purges due definitions every interval until stop is closed.
+func (p *Purger) Run(stop <-chan struct{}) {
+	t := time.NewTicker(p.Interval)
+	defer t.Stop()
+
+	for {
+		if err := p.RunOnce(time.Now()); err != nil {
+			p.Log.Printf("purge error: %s", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// RunOnce purges the archived definitions that are due at the given time.
+func (p *Purger) RunOnce(now time.Time) error {
+	defs, _, err := p.Store.GetDefs(DefsQuery{State: DefsArchived})
+	if err != nil {
+		return err
+	}
+
+	for _, def := range defs {
+		if !def.Deleted {
+			continue
+		}
+
+		st := p.get(def, now)
+
+		if now.Before(*st.Due) {
+			continue
+		}
+
+		p.purge(def, st)
+	}
+
+	return nil
+}
+
+// get returns the status of the archived definition. Definitions archived
+// before the time was recorded start their grace period when first seen.
+func (p *Purger) get(def *Def, now time.Time) *PurgeStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st, ok := p.status[def.ID]
+	if !ok {
+		st = &PurgeStatus{
+			ID:    def.ID,
+			Name:  def.Name,
+			State: PurgePending,
+			seen:  now,
+		}
+		p.status[def.ID] = st
+	}
+
+	archived := st.seen
+	if def.ArchivedAt != nil {
+		archived = *def.ArchivedAt
+	}
+
+	due := archived.Add(p.Grace).UTC()
+	st.Due = &due
+
+	return st
+}
+
+func (p *Purger) update(fn func()) {
+	p.mu.Lock()
+	fn()
+	p.mu.Unlock()
+}
+
+// start marks the purge as running unless the definition was restored since
+// it was listed. The check is done while holding the lock so it cannot
+// interleave with Restore.
+func (p *Purger) start(def *Def, st *PurgeStatus) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cur, err := p.Store.GetDefByID(def.ID)
+	if err != nil {
+		return false, err
+	}
+
+	if !cur.Deleted {
+		delete(p.status, def.ID)
+		return false, nil
+	}
+
+	now := time.Now().UTC()
+
+	st.State = PurgeRunning
+	st.Started = &now
+	st.Error = ""
+
+	return true, nil
+}
+
+// Restore calls fn to restore the archived definition unless its purge has
+// started, in which case ErrPurged is returned.
+func (p *Purger) Restore(id int, fn func() error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if st, ok := p.status[id]; ok && st.State != PurgePending {
+		return ErrPurged
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	delete(p.status, id)
+
+	return nil
+}
+
+func (p *Purger) purge(def *Def, st *PurgeStatus) {
+	ok, err := p.start(def, st)
+	if err != nil {
+		p.Log.Printf("purge of '%s' failed: %s", def.Name, err)
+		return
+	}
+
+	if !ok {
+		return
+	}
+
+	p.Log.Printf("purging '%s' (id=%d)", def.Name, def.ID)
+
+	for {
+		n, done, err := p.Store.Purge(def, PurgeBatchSize)
+
+		p.update(func() {
+			st.Removed += n
+		})
+
+		if err != nil {
+			p.update(func() {
+				st.State = PurgeFailed
+				st.Error = err.Error()
+			})
+
+			p.Log.Printf("purge of '%s' failed after %d keys: %s", def.Name, st.Removed, err)
+			return
+		}
+
+		if done {
+			break
+		}
+
+		p.Log.Printf("purged %d keys of '%s'", st.Removed, def.Name)
+	}
+
+	finished := time.Now().UTC()
+
+	p.update(func() {
+		st.State = PurgeDone
+		st.Finished = &finished
+	})
+
+	p.Log.Printf("purged '%s' (%d keys)", def.Name, st.Removed)
+}
+
+// Status returns the status of the archived definitions known to the purger
+// ordered by ID.
+func (p *Purger) Status() []*PurgeStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l := make([]*PurgeStatus, 0, len(p.status))
+
+	for _, st := range p.status {
+		c := *st
+		l = append(l, &c)
+	}
+
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].ID < l[j].ID
+	})
+
+	return l
+}
+
+// StatusOf returns the status of the archived definition by ID.
+func (p *Purger) StatusOf(id int) (*PurgeStatus, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st, ok := p.status[id]
+	if !ok {
+		return nil, false
+	}
+
+	c := *st
+	return &c, true
+}
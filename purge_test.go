@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPurger(t *testing.T) {
+	s := initServer(t)
+
+	for _, name := range []string{"a", "b"} {
+		def := NewDef()
+		def.Name = name
+		def.Type = "rand"
+
+		if err := s.CreateDef(def); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := s.Gen(def, []*IdentAlias{{Ident: "1"}, {Ident: "2"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.DelDef("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewPurger(s.Store, time.Hour, s.Log)
+
+	// Within the grace period.
+	if err := p.RunOnce(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	st, ok := p.StatusOf(1)
+	if !ok || st.State != PurgePending || st.Due == nil {
+		t.Fatalf("expected pending purge, got %+v", st)
+	}
+
+	if defs, _, _ := s.GetDefs(DefsQuery{}); len(defs) != 2 {
+		t.Fatalf("expected archived def to be kept, got %d defs", len(defs))
+	}
+
+	// After the grace period.
+	if err := p.RunOnce(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	st, _ = p.StatusOf(1)
+	if st.State != PurgeDone || st.Removed != 2 || st.Finished == nil {
+		t.Errorf("expected done purge, got %+v", st)
+	}
+
+	defs, _, err := s.GetDefs(DefsQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(defs) != 1 || defs[0].Name != "b" {
+		t.Errorf("expected only active def, got %v", defs)
+	}
+
+	if l := p.Status(); len(l) != 1 {
+		t.Errorf("expected 1 status, got %d", len(l))
+	}
+}
+
+func TestPurgerRestore(t *testing.T) {
+	s := initServer(t)
+	s.Purger = NewPurger(s.Store, time.Hour, s.Log)
+
+	for _, name := range []string{"a", "b"} {
+		def := NewDef()
+		def.Name = name
+		def.Type = "uuid"
+
+		if err := s.CreateDef(def); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.DelDef(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Purger.RunOnce(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pending purges can be restored.
+	if _, err := s.RestoreDef("a", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.Purger.StatusOf(1); ok {
+		t.Error("expected restored def to be removed from purge status")
+	}
+
+	if err := s.Purger.RunOnce(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetDef("a"); err != nil {
+		t.Errorf("expected restored def to be kept, got %v", err)
+	}
+
+	if _, err := s.RestoreDef("b", 0); err != ErrNoDef {
+		t.Errorf("expected purged def to not be found, got %v", err)
+	}
+
+	// Archive a and mark its purge as running.
+	if err := s.DelDef("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Purger.RunOnce(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Purger.status[1].State = PurgeRunning
+
+	if _, err := s.RestoreDef("a", 0); err != ErrPurged {
+		t.Errorf("expected ErrPurged, got %v", err)
+	}
+}
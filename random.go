@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	crand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+)
+
+// Source supplies the randomness of the rand, words, and pattern generators.
+type Source interface {
+	// Intn returns a uniformly distributed int in [0, n).
+	Intn(n int) (int, error)
+}
+
+// mathSources holds the math source of each definition by store, ID, and
+// seed.
+var mathSources = struct {
+	sync.Mutex
+	m map[mathSourceKey]*mathSource
+}{m: make(map[mathSourceKey]*mathSource)}
+
+type mathSourceKey struct {
+	store Store
+	id    int
+	seed  int64
+}
+
+// NewSource returns the entropy source of the definition. The default is the
+// operating system's secure random number generator. The math source is a
+// pseudo-random generator seeded with Seed, so its aliases are reproducible
+// and must only be used for testing.
+func NewSource(def *Def) (Source, error) {
+	switch def.Source {
+	case "", "crypto":
+		return newCryptoSource(crand.Reader), nil
+
+	case "math":
+		return &mathSource{r: rand.New(rand.NewSource(def.Seed))}, nil
+	}
+
+	return nil, fmt.Errorf("unknown source '%s'", def.Source)
+}
+
+// defSource returns the source of the generator of the definition. The math
+// source of a definition is kept for the lifetime of the process, so each
+// request continues the sequence instead of replaying the candidates of the
+// previous one, which would all be taken.
+func defSource(st Store, def *Def) (Source, error) {
+	if def.Source != "math" {
+		return NewSource(def)
+	}
+
+	key := mathSourceKey{store: st, id: def.ID, seed: def.Seed}
+
+	mathSources.Lock()
+	defer mathSources.Unlock()
+
+	if src, ok := mathSources.m[key]; ok {
+		return src, nil
+	}
+
+	src, err := NewSource(def)
+	if err != nil {
+		return nil, err
+	}
+
+	mathSources.m[key] = src.(*mathSource)
+
+	return src, nil
+}
+
+// cryptoSource draws unbiased indexes from a secure random stream. It is not
+// safe for concurrent use.
+type cryptoSource struct {
+	r *bufio.Reader
+}
+
+func newCryptoSource(r io.Reader) *cryptoSource {
+	return &cryptoSource{r: bufio.NewReaderSize(r, 256)}
+}
+
+// Intn draws 32-bit values and rejects those in the partial range above the
+// largest multiple of n, so every index is equally likely.
+func (s *cryptoSource) Intn(n int) (int, error) {
+	if n <= 0 || uint64(n) > 1<<32 {
+		return 0, fmt.Errorf("invalid range %d", n)
+	}
+
+	var (
+		b     [4]byte
+		limit = 1 << 32 / uint64(n) * uint64(n)
+	)
+
+	for {
+		if _, err := io.ReadFull(s.r, b[:]); err != nil {
+			return 0, err
+		}
+
+		if v := uint64(binary.BigEndian.Uint32(b[:])); v < limit {
+			return int(v % uint64(n)), nil
+		}
+	}
+}
+
+// mathSource is a deterministic pseudo-random source. It is safe for
+// concurrent use since it is shared by the requests of a definition.
+type mathSource struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (s *mathSource) Intn(n int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.r.Intn(n), nil
+}
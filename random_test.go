@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCryptoSource(t *testing.T) {
+	// 0xffffffff is above the largest multiple of 3 below 2^32, so it is
+	// rejected and the next value is used.
+	src := newCryptoSource(bytes.NewReader([]byte{
+		0xff, 0xff, 0xff, 0xff,
+		0x00, 0x00, 0x00, 0x05,
+	}))
+
+	v, err := src.Intn(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 2 {
+		t.Errorf("expected 2, got %d", v)
+	}
+
+	// Exhausted.
+	if _, err := src.Intn(3); err == nil {
+		t.Error("expected error for exhausted reader")
+	}
+}
+
+func TestSource(t *testing.T) {
+	st := NewMemoryStore()
+
+	def := NewDef()
+	def.Name = "rand"
+	def.Type = "rand"
+	def.Source = "math"
+	def.Seed = 42
+
+	gen := func() string {
+		g, err := MakeGen(st, nil, def)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		alias, err := g.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return alias
+	}
+
+	// Requests continue the sequence.
+	first := gen()
+
+	if a := gen(); a == first {
+		t.Errorf("expected the next request to continue the sequence, got %s twice", a)
+	}
+
+	// A new process replays it.
+	st = NewMemoryStore()
+
+	if a := gen(); a != first {
+		t.Errorf("expected seeded aliases to match, got %s and %s", first, a)
+	}
+
+	def.Source = ""
+
+	if a, b := gen(), gen(); a == b {
+		t.Errorf("expected crypto aliases to differ, got %s twice", a)
+	}
+
+	def.Source = "time"
+
+	if _, err := MakeGen(st, nil, def); err == nil {
+		t.Error("expected error for unknown source")
+	}
+}
@@ -0,0 +1,1033 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	// DefaultIdleTimeout sets the duration after which idle Redis connections
+	// in the pool are closed.
+	DefaultIdleTimeout = 5 * time.Minute
+	// DefaultMaxIdle is the number of idle Redis connections allowed in the pool.
+	DefaultMaxIdle = 3
+
+	// Prefix for internal use.
+	internalPrefix = "_:%s"
+
+	// Registry of definition IDs, including archived ones, and of the IDs of
+	// the active and archived ones.
+	defsKey         = mk(internalPrefix, "defs")
+	activeDefsKey   = mk(internalPrefix, "defs:active")
+	archivedDefsKey = mk(internalPrefix, "defs:archived")
+	// Marks the registries as backfilled with the definitions created before
+	// they existed. The value is the version of the registries.
+	defsIndexedKey   = mk(internalPrefix, "defs:indexed")
+	defsIndexVersion = 2
+
+	// Prefix for index definitions.
+	defPrefix   = "d:%s"
+	valuePrefix = "v:%d"
+	seqPrefix   = "s:%d"
+	countPrefix = "c:%d"
+
+	// Prefix for keys, aliases, and sequences.
+	// These are scoped by the definition id.
+	keyPrefix   = "k:%d:%s"
+	aliasPrefix = "a:%d:%s"
+)
+
+// claimScript atomically looks up the ident and sets the alias if neither
+// is taken. It returns the status (StatusExists or StatusCreated) and alias,
+// or a zero status and empty alias if the alias is taken. The alias count is
+// incremented if it has been initialized.
+//
+//	KEYS[1] k:<id>:<ident>
+//	KEYS[2] a:<id>:<alias>
+//	KEYS[3] c:<id>
+//	ARGV[1] alias
+//	ARGV[2] value of the alias entry
+var claimScript = redis.NewScript(3, `
+local cur = redis.call('GET', KEYS[1])
+if cur then
+	return {1, cur}
+end
+
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	return {0, ''}
+end
+
+redis.call('MSET', KEYS[1], ARGV[1], KEYS[2], ARGV[2])
+
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	redis.call('INCR', KEYS[3])
+end
+
+return {2, ARGV[1]}
+`)
+
+// setScript sets the key and alias entries and increments the alias count by
+// the alias entries that did not exist, if it has been initialized.
+//
+//	KEYS[1] c:<id>
+//	KEYS[2n] k:<id>:<ident>
+//	KEYS[2n+1] a:<id>:<alias>
+//	ARGV[2n-1] alias
+//	ARGV[2n] value of the alias entry
+var setScript = redis.NewScript(-1, `
+local n = 0
+for i = 2, #KEYS, 2 do
+	n = n + 1 - redis.call('EXISTS', KEYS[i+1])
+	redis.call('MSET', KEYS[i], ARGV[i-1], KEYS[i+1], ARGV[i])
+end
+
+if n > 0 and redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('INCRBY', KEYS[1], n)
+end
+
+return n
+`)
+
+// delScript deletes the key and alias entries and decrements the alias count
+// by the alias entries that existed, if it has been initialized.
+//
+//	KEYS[1] c:<id>
+//	KEYS[2n] k:<id>:<ident>
+//	KEYS[2n+1] a:<id>:<alias>
+var delScript = redis.NewScript(-1, `
+local n = 0
+for i = 2, #KEYS, 2 do
+	redis.call('DEL', KEYS[i])
+	n = n + redis.call('DEL', KEYS[i+1])
+end
+
+if n > 0 and redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('DECRBY', KEYS[1], n)
+end
+
+return n
+`)
+
+// restoreScript sets the name entry of an archived definition unless it is
+// taken, updates the value, and moves the ID to the active registry. It
+// returns 1 if restored, 0 if the name is taken, and -1 if the value no
+// longer exists.
+//
+//	KEYS[1] d:<name>
+//	KEYS[2] v:<id>
+//	KEYS[3] _:defs:active
+//	KEYS[4] _:defs:archived
+//	ARGV[1] id
+//	ARGV[2] value
+var restoreScript = redis.NewScript(4, `
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	return -1
+end
+
+if redis.call('SETNX', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+
+redis.call('SET', KEYS[2], ARGV[2])
+redis.call('ZREM', KEYS[4], ARGV[1])
+redis.call('ZADD', KEYS[3], ARGV[1], ARGV[1])
+return 1
+`)
+
+// defStateScript adds the ID of the definition to the active or archived
+// registry by the value, and removes it from the other one. The value is
+// read by the script so a concurrent archive or restore is not undone.
+//
+//	KEYS[1] v:<id>
+//	KEYS[2] _:defs:active
+//	KEYS[3] _:defs:archived
+//	ARGV[1] id
+var defStateScript = redis.NewScript(3, `
+local val = redis.call('GET', KEYS[1])
+if not val then
+	return 0
+end
+
+local add, rem = KEYS[2], KEYS[3]
+if cjson.decode(val)['archived'] then
+	add, rem = KEYS[3], KEYS[2]
+end
+
+redis.call('ZREM', rem, ARGV[1])
+redis.call('ZADD', add, ARGV[1], ARGV[1])
+return 1
+`)
+
+// countScript returns the alias count. If it has not been initialized, the
+// alias entries are counted and the count is set in the same script, so no
+// alias can be claimed or deleted in between. Writes after SCAN require the
+// effects of the script to be replicated instead of the script itself.
+//
+//	KEYS[1] c:<id>
+//	ARGV[1] a:<id>:*
+var countScript = redis.NewScript(1, `
+local n = redis.call('GET', KEYS[1])
+if n then
+	return tonumber(n)
+end
+
+redis.replicate_commands()
+
+local cursor = '0'
+n = 0
+repeat
+	local res = redis.call('SCAN', cursor, 'MATCH', ARGV[1], 'COUNT', 1000)
+	cursor = res[1]
+	n = n + #res[2]
+until cursor == '0'
+
+redis.call('SET', KEYS[1], n)
+return n
+`)
+
+// seqScript increments the sequence. If the legacy sequence key exists and
+// the definition is the active one of its name, it is first merged into the
+// sequence by keeping the larger of the two, so aliases issued under it are
+// not reissued.
+//
+//	KEYS[1] s:<id>
+//	KEYS[2] legacy sequence key
+//	KEYS[3] d:<name>
+//	ARGV[1] increment
+//	ARGV[2] id
+var seqScript = redis.NewScript(3, `
+local legacy = redis.call('GET', KEYS[2])
+if legacy and redis.call('GET', KEYS[3]) == ARGV[2] then
+	local cur = tonumber(redis.call('GET', KEYS[1]) or 0)
+	if tonumber(legacy) > cur then
+		redis.call('SET', KEYS[1], legacy)
+	end
+	redis.call('DEL', KEYS[2])
+end
+
+return redis.call('INCRBY', KEYS[1], ARGV[1])
+`)
+
+func mk(f string, v ...interface{}) string {
+	return fmt.Sprintf(f, v...)
+}
+
+// RedisStore is a Store backed by Redis.
+//
+//	_:def:id -> 1
+//	_:defs -> {<id>, ...}
+//	_:defs:active -> {<id>, ...}
+//	_:defs:archived -> {<id>, ...}
+//	_:defs:indexed -> 2
+//	d:<name> -> <id>
+//	v:<id> -> { ... }
+//	s:<id> -> <seq>
+//	c:<id> -> <number of alias entries>
+//	k:<id>:<ident> -> <alias>
+//	a:<id>:<alias> -> 1, or <ident> if the def has a reverse index
+type RedisStore struct {
+	Log  *log.Logger
+	Pool *redis.Pool
+
+	mu sync.Mutex
+
+	// Whether the definition registry has been backfilled.
+	indexed bool
+
+	// Scan positions of purges in progress by definition ID.
+	purges map[int]*redisPurge
+}
+
+// redisPurge is the position of an incremental purge. The key patterns are
+// scanned in turn.
+type redisPurge struct {
+	pattern int
+	cursor  int64
+}
+
+// NewRedisStore returns a store with a pool of connections to the Redis server.
+func NewRedisStore(addr string, db int, pass string, tls bool, logger *log.Logger) *RedisStore {
+	return &RedisStore{
+		Log: logger,
+		Pool: &redis.Pool{
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial(
+					"tcp",
+					addr,
+					redis.DialDatabase(db),
+					redis.DialPassword(pass),
+					redis.DialUseTLS(tls),
+				)
+			},
+			IdleTimeout: DefaultIdleTimeout,
+			MaxIdle:     DefaultMaxIdle,
+		},
+	}
+}
+
+func (s *RedisStore) handleClose(c io.Closer) {
+	err := c.Close()
+	if err != nil {
+		s.Log.Printf("close error: %s\n", err)
+	}
+}
+
+// Close closes the connection pool.
+func (s *RedisStore) Close() error {
+	return s.Pool.Close()
+}
+
+// indexDefs adds the IDs of definitions created before the registries
+// existed. The value keys are scanned incrementally rather than with KEYS, and
+// keys that are not definitions are skipped. Definitions may have been added
+// to the registries before the first listing, so completion is tracked by its
+// own marker key rather than by the existence of the registries.
+func (s *RedisStore) indexDefs(conn redis.Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.indexed {
+		return nil
+	}
+
+	version, err := redis.Int(conn.Do("GET", defsIndexedKey))
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+
+	if version < 1 {
+		var (
+			cursor int64
+			count  int
+		)
+
+		for {
+			vals, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", "v:*", "COUNT", 1000))
+			if err != nil {
+				return err
+			}
+
+			var keys []string
+			if _, err := redis.Scan(vals, &cursor, &keys); err != nil {
+				return err
+			}
+
+			for _, key := range keys {
+				id, err := strconv.Atoi(key[2:])
+				if err != nil || mk(valuePrefix, id) != key {
+					continue
+				}
+
+				if _, err := conn.Do("ZADD", defsKey, id, id); err != nil {
+					return err
+				}
+
+				count++
+			}
+
+			if cursor == 0 {
+				break
+			}
+		}
+
+		if count > 0 {
+			s.Log.Printf("indexed %d defs", count)
+		}
+	}
+
+	// The registries of active and archived definitions were added in the
+	// second version.
+	if version < 2 {
+		ids, err := redis.Ints(conn.Do("ZRANGE", defsKey, 0, -1))
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if _, err := defStateScript.Do(conn, mk(valuePrefix, id), activeDefsKey, archivedDefsKey, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	if version < defsIndexVersion {
+		if _, err := conn.Do("SET", defsIndexedKey, defsIndexVersion); err != nil {
+			return err
+		}
+	}
+
+	s.indexed = true
+
+	return nil
+}
+
+// GetDefs retrieves the page of definitions of the registry of the state
+// ordered by ID.
+func (s *RedisStore) GetDefs(q DefsQuery) ([]*Def, int, error) {
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	if err := s.indexDefs(conn); err != nil {
+		return nil, 0, err
+	}
+
+	key := defsKey
+
+	switch q.State {
+	case DefsActive:
+		key = activeDefsKey
+	case DefsArchived:
+		key = archivedDefsKey
+	}
+
+	stop := -1
+	if q.Limit > 0 {
+		stop = q.Offset + q.Limit - 1
+	}
+
+	conn.Send("MULTI")
+	conn.Send("ZCARD", key)
+	conn.Send("ZRANGE", key, q.Offset, stop)
+
+	vals, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		total int
+		ids   []int
+	)
+
+	if _, err := redis.Scan(vals, &total, &ids); err != nil {
+		return nil, 0, err
+	}
+
+	if len(ids) == 0 {
+		return []*Def{}, total, nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = mk(valuePrefix, id)
+	}
+
+	blobs, err := redis.ByteSlices(conn.Do("MGET", args...))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defs := make([]*Def, 0, len(blobs))
+
+	for i, blob := range blobs {
+		if blob == nil {
+			s.Log.Printf("missing def value for id %d", ids[i])
+			continue
+		}
+
+		var def Def
+		if err := json.Unmarshal(blob, &def); err != nil {
+			return nil, 0, err
+		}
+		defs = append(defs, &def)
+	}
+
+	return defs, total, nil
+}
+
+// DelDef deletes the name entry and updates the definition.
+func (s *RedisStore) DelDef(def *Def) error {
+	b, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	// Delete name entry to make inaccessable and update definition.
+	conn.Send("MULTI")
+	conn.Send("DEL", mk(defPrefix, def.Name))
+	conn.Send("SET", mk(valuePrefix, def.ID), string(b))
+	conn.Send("ZADD", defsKey, def.ID, def.ID)
+	conn.Send("ZREM", activeDefsKey, def.ID)
+	conn.Send("ZADD", archivedDefsKey, def.ID, def.ID)
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// GetDef retrieves an existing definition.
+func (s *RedisStore) GetDef(name string) (*Def, error) {
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	id, err := redis.Int64(conn.Do("GET", mk(defPrefix, name)))
+	if err == redis.ErrNil {
+		return nil, ErrNoDef
+	} else if err != nil {
+		return nil, err
+	}
+
+	blob, err := redis.Bytes(conn.Do("GET", mk(valuePrefix, id)))
+	if err != nil {
+		return nil, err
+	}
+
+	if blob == nil {
+		panic(fmt.Sprintf("missing def value for %s", name))
+	}
+
+	var g Def
+	if err := json.Unmarshal(blob, &g); err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+// GetDefByID retrieves a definition by ID.
+func (s *RedisStore) GetDefByID(id int) (*Def, error) {
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	blob, err := redis.Bytes(conn.Do("GET", mk(valuePrefix, id)))
+	if err == redis.ErrNil {
+		return nil, ErrNoDef
+	} else if err != nil {
+		return nil, err
+	}
+
+	var g Def
+	if err := json.Unmarshal(blob, &g); err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+// RestoreDef sets the name entry unless it is taken and updates the
+// definition.
+func (s *RedisStore) RestoreDef(def *Def) error {
+	b, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	n, err := redis.Int(restoreScript.Do(conn, mk(defPrefix, def.Name), mk(valuePrefix, def.ID), activeDefsKey, archivedDefsKey, def.ID, string(b)))
+	if err != nil {
+		return err
+	}
+
+	switch n {
+	case 0:
+		return ErrDefExists
+	case -1:
+		return ErrNoDef
+	}
+
+	return nil
+}
+
+// CreateDef creates a new definition.
+// d:foo -> 0
+// v:0 -> { ... }
+func (s *RedisStore) CreateDef(def *Def) error {
+	// Check if there is an existing definition.
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	// Lookup up def by name.
+	defKey := mk(defPrefix, def.Name)
+
+	exists, err := redis.Bool(conn.Do("EXISTS", defKey))
+	if err != nil {
+		return err
+	}
+
+	// Cannot create a def by the same name.
+	if exists {
+		return ErrDefExists
+	}
+
+	// Get a new key.
+	defIDKey := mk(internalPrefix, "def:id")
+	id, err := redis.Int64(conn.Do("INCR", defIDKey))
+	if err != nil {
+		return err
+	}
+
+	def.ID = int(id)
+
+	b, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+
+	valueKey := mk(valuePrefix, def.ID)
+
+	args := []interface{}{
+		defKey, def.ID,
+		valueKey, string(b),
+		mk(countPrefix, def.ID), 0,
+	}
+
+	// Initialize the sequence.
+	if def.Sequential() {
+		seqKey := mk(seqPrefix, def.ID)
+		args = append(args, seqKey, def.seqInit())
+	}
+
+	conn.Send("MULTI")
+	conn.Send("MSET", args...)
+	conn.Send("ZADD", defsKey, def.ID, def.ID)
+	conn.Send("ZADD", activeDefsKey, def.ID, def.ID)
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// UpdateDef updates an existing definition.
+func (s *RedisStore) UpdateDef(name string, def *Def) error {
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	b, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+
+	// Delete previous definition.
+	if name != def.Name {
+		_, err = conn.Do("DEL", mk(defPrefix, name))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Set name and value key.
+	defKey := mk(defPrefix, def.Name)
+	valueKey := mk(valuePrefix, def.ID)
+
+	add, rem := activeDefsKey, archivedDefsKey
+	if def.Deleted {
+		add, rem = rem, add
+	}
+
+	conn.Send("MULTI")
+	conn.Send("MSET", defKey, def.ID, valueKey, string(b))
+	conn.Send("ZADD", defsKey, def.ID, def.ID)
+	conn.Send("ZREM", rem, def.ID)
+	conn.Send("ZADD", add, def.ID, def.ID)
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// Lookup gets the aliases of the idents with a single MGET.
+func (s *RedisStore) Lookup(def *Def, idents []*IdentAlias) error {
+	if len(idents) == 0 {
+		return nil
+	}
+
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	args := make([]interface{}, len(idents))
+	for i, ia := range idents {
+		args[i] = mk(keyPrefix, def.ID, ia.Ident)
+	}
+
+	vals, err := redis.Values(conn.Do("MGET", args...))
+	if err != nil {
+		return err
+	}
+
+	for i, ia := range idents {
+		// Missing.
+		if vals[i] == nil {
+			ia.Status = StatusMissing
+			continue
+		}
+
+		alias, err := redis.String(vals[i], nil)
+		if err != nil {
+			return err
+		}
+
+		ia.Alias = alias
+		ia.Status = StatusExists
+	}
+
+	return nil
+}
+
+// Claim assigns the aliases to the idents if neither is taken. Each lookup
+// and set is done in a single script so concurrent claims cannot interleave.
+// The script calls are pipelined.
+func (s *RedisStore) Claim(def *Def, idents []*IdentAlias) error {
+	if len(idents) == 0 {
+		return nil
+	}
+
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	// Ensure the script is cached so EVALSHA can be pipelined.
+	if err := claimScript.Load(conn); err != nil {
+		return err
+	}
+
+	countKey := mk(countPrefix, def.ID)
+
+	for _, ia := range idents {
+		lookupKey := mk(keyPrefix, def.ID, ia.Ident)
+		checkKey := mk(aliasPrefix, def.ID, ia.Alias)
+
+		if err := claimScript.SendHash(conn, lookupKey, checkKey, countKey, ia.Alias, redisAliasValue(def, ia.Ident)); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	for _, ia := range idents {
+		vals, err := redis.Values(conn.Receive())
+		if err != nil {
+			return err
+		}
+
+		var status int
+
+		if _, err := redis.Scan(vals, &status, &ia.Alias); err != nil {
+			return err
+		}
+
+		ia.Status = Status(status)
+	}
+
+	return nil
+}
+
+// Set sets the key and alias entries of the idents in a single script.
+func (s *RedisStore) Set(def *Def, idents []*IdentAlias) error {
+	if len(idents) == 0 {
+		return nil
+	}
+
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	keys := make([]interface{}, 0, 2+2*len(idents))
+	vals := make([]interface{}, 0, 2*len(idents))
+
+	keys = append(keys, 1+2*len(idents), mk(countPrefix, def.ID))
+
+	for _, ia := range idents {
+		// key to alias
+		lookupKey := mk(keyPrefix, def.ID, ia.Ident)
+		// alias entry for existence check and reverse lookup.
+		checkKey := mk(aliasPrefix, def.ID, ia.Alias)
+
+		keys = append(keys, lookupKey, checkKey)
+		vals = append(vals, ia.Alias, redisAliasValue(def, ia.Ident))
+	}
+
+	_, err := setScript.Do(conn, append(keys, vals...)...)
+	return err
+}
+
+// redisAliasValue returns the value of the alias entry, the ident if the
+// definition has a reverse index.
+func redisAliasValue(def *Def, ident string) interface{} {
+	if def.Reverse {
+		return ident
+	}
+
+	return true
+}
+
+// Reverse gets the idents of the aliases from the alias entries.
+func (s *RedisStore) Reverse(def *Def, aliases []*IdentAlias) error {
+	if len(aliases) == 0 {
+		return nil
+	}
+
+	for _, ia := range aliases {
+		ia.Status = StatusMissing
+	}
+
+	// Entries of definitions without a reverse index only mark the alias
+	// as taken.
+	if !def.Reverse {
+		return nil
+	}
+
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	args := make([]interface{}, len(aliases))
+	for i, ia := range aliases {
+		args[i] = mk(aliasPrefix, def.ID, ia.Alias)
+	}
+
+	idents, err := redis.Values(conn.Do("MGET", args...))
+	if err != nil {
+		return err
+	}
+
+	for i, v := range idents {
+		if v == nil {
+			continue
+		}
+
+		ident, err := redis.String(v, nil)
+		if err != nil {
+			return err
+		}
+
+		aliases[i].Ident = ident
+		aliases[i].Status = StatusExists
+	}
+
+	return nil
+}
+
+// Del deletes the key and alias entries of the idents.
+func (s *RedisStore) Del(def *Def, idents []string) (int, error) {
+	if len(idents) == 0 {
+		return 0, nil
+	}
+
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	args := make([]interface{}, len(idents))
+	for i, ident := range idents {
+		args[i] = mk(keyPrefix, def.ID, ident)
+	}
+
+	// Get the corresponding aliases.
+	aliases, err := redis.Values(conn.Do("MGET", args...))
+	if err != nil {
+		return 0, err
+	}
+
+	keys := []interface{}{0, mk(countPrefix, def.ID)}
+
+	for i, v := range aliases {
+		if v == nil {
+			continue
+		}
+
+		alias, err := redis.String(v, nil)
+		if err != nil {
+			return 0, err
+		}
+
+		keys = append(keys, args[i], mk(aliasPrefix, def.ID, alias))
+	}
+
+	n := (len(keys) - 2) / 2
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	keys[0] = len(keys) - 1
+
+	if _, err := delScript.Do(conn, keys...); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Idents scans the key entries of the definition.
+func (s *RedisStore) Idents(def *Def) ([]string, error) {
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	var (
+		idents []string
+		cursor int64
+		prefix = mk(keyPrefix, def.ID, "")
+	)
+
+	for {
+		vals, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", prefix+"*", "COUNT", 1000))
+		if err != nil {
+			return nil, err
+		}
+
+		var keys []string
+		if _, err := redis.Scan(vals, &cursor, &keys); err != nil {
+			return nil, err
+		}
+
+		for _, k := range keys {
+			idents = append(idents, strings.TrimPrefix(k, prefix))
+		}
+
+		if cursor == 0 {
+			return idents, nil
+		}
+	}
+}
+
+// RenameIdents renames the key entries with pipelined RENAMENX commands.
+func (s *RedisStore) RenameIdents(def *Def, from, to []string) error {
+	if len(from) == 0 {
+		return nil
+	}
+
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	for i, ident := range from {
+		if err := conn.Send("RENAMENX", mk(keyPrefix, def.ID, ident), mk(keyPrefix, def.ID, to[i])); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	var exists []string
+
+	for i := range from {
+		ok, err := redis.Bool(conn.Receive())
+
+		// The key no longer exists.
+		if rerr, isErr := err.(redis.Error); isErr && strings.Contains(string(rerr), "no such key") {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			exists = append(exists, to[i])
+		}
+	}
+
+	if len(exists) > 0 {
+		return fmt.Errorf("idents %s exist", strings.Join(exists, ", "))
+	}
+
+	return nil
+}
+
+// Count returns the alias count of the definition. The count of definitions
+// created before it was kept is initialized by counting the alias entries
+// once, which blocks other commands until it is done.
+func (s *RedisStore) Count(def *Def) (int, error) {
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	return redis.Int(countScript.Do(conn, mk(countPrefix, def.ID), mk(aliasPrefix, def.ID, "*")))
+}
+
+// NextSeq increments the sequence of the definition.
+func (s *RedisStore) NextSeq(def *Def, n int64) (int64, error) {
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	return redis.Int64(seqScript.Do(conn, mk(seqPrefix, def.ID), legacySeqKey(def), mk(defPrefix, def.Name), n, def.ID))
+}
+
+// legacySeqKey returns the key sequences were incremented under before they
+// were scoped by the definition id. The format verb was never applied, so
+// the name was appended to the literal prefix. It is shared by the archived
+// and active definitions of the name, so only the active one merges it.
+func legacySeqKey(def *Def) string {
+	return seqPrefix + def.Name
+}
+
+// Purge scans and deletes the key and alias entries of the definition. The
+// scan position is kept between calls so each call resumes where the last
+// one stopped. Once both patterns have been fully scanned, the sequence,
+// count, value, and registry entry are deleted. The legacy sequence key is
+// left to the active definition of the name.
+func (s *RedisStore) Purge(def *Def, n int) (int, bool, error) {
+	conn := s.Pool.Get()
+	defer s.handleClose(conn)
+
+	s.mu.Lock()
+	if s.purges == nil {
+		s.purges = make(map[int]*redisPurge)
+	}
+	p, ok := s.purges[def.ID]
+	if !ok {
+		p = &redisPurge{}
+		s.purges[def.ID] = p
+	}
+	s.mu.Unlock()
+
+	patterns := []string{
+		mk(keyPrefix, def.ID, "*"),
+		mk(aliasPrefix, def.ID, "*"),
+	}
+
+	var removed, deleted int
+
+	for p.pattern < len(patterns) && deleted < n {
+		vals, err := redis.Values(conn.Do("SCAN", p.cursor, "MATCH", patterns[p.pattern], "COUNT", n))
+		if err != nil {
+			return removed, false, err
+		}
+
+		var keys []interface{}
+		if _, err := redis.Scan(vals, &p.cursor, &keys); err != nil {
+			return removed, false, err
+		}
+
+		if len(keys) > 0 {
+			if _, err := conn.Do("DEL", keys...); err != nil {
+				return removed, false, err
+			}
+
+			// Only the key entries count as removed idents.
+			if p.pattern == 0 {
+				removed += len(keys)
+			}
+
+			deleted += len(keys)
+		}
+
+		if p.cursor == 0 {
+			p.pattern++
+		}
+	}
+
+	if p.pattern < len(patterns) {
+		return removed, false, nil
+	}
+
+	conn.Send("MULTI")
+	conn.Send("DEL", mk(seqPrefix, def.ID), mk(countPrefix, def.ID), mk(valuePrefix, def.ID))
+	conn.Send("ZREM", defsKey, def.ID)
+	conn.Send("ZREM", activeDefsKey, def.ID)
+	conn.Send("ZREM", archivedDefsKey, def.ID)
+	if _, err := conn.Do("EXEC"); err != nil {
+		return removed, false, err
+	}
+
+	s.mu.Lock()
+	delete(s.purges, def.ID)
+	s.mu.Unlock()
+
+	return removed, true, nil
+}
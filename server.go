@@ -1,31 +1,52 @@
 package main
 
 import (
+	crand "crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
-
-	"github.com/garyburd/redigo/redis"
 )
 
 var (
-	// DefaultIdleTimeout sets the duration after which idle Redis connections
-	// in the pool are closed.
-	DefaultIdleTimeout = 5 * time.Minute
-	// DefaultMaxIdle is the number of idle Redis connections allowed in the pool.
-	DefaultMaxIdle = 3
-
 	// ErrNoDef is returned when a user attempts to get a definition that does
 	// not exist.
 	ErrNoDef = errors.New("no def")
 	// ErrDefExists is returned when the user attempts to create a definition
 	// that already exists.
 	ErrDefExists = errors.New("def exists")
+	// ErrDefNotArchived is returned when the user attempts to restore a
+	// definition that is not archived.
+	ErrDefNotArchived = errors.New("def not archived")
+	// ErrNotReversible is returned when decoding aliases of a definition whose
+	// generator does not support it.
+	ErrNotReversible = errors.New("aliases cannot be decoded")
+	// ErrNoReverseIndex is returned when looking up the idents of aliases of a
+	// definition created without a reverse index.
+	ErrNoReverseIndex = errors.New("def has no reverse index")
+	// ErrReverseChanged is returned when updating whether a definition has a
+	// reverse index, since existing alias entries would not match.
+	ErrReverseChanged = errors.New("reverse index can only be set on creation")
+	// ErrIdentKeyChanged is returned when updating the ident key of a
+	// definition. Existing idents are hashed with MigrateIdents.
+	ErrIdentKeyChanged = errors.New("ident key can only be set on creation or by migration")
+	// ErrMigrating is returned when passing idents to a definition whose
+	// idents are being hashed by MigrateIdents.
+	ErrMigrating = errors.New("idents are being migrated")
+	// ErrNoAudit is returned when looking up idents without an audit log.
+	ErrNoAudit = errors.New("reverse lookups require an audit log")
+	// ErrNoReason is returned when looking up idents without a reason.
+	ErrNoReason = errors.New("reason required")
+	// ErrDecodeIdents is returned when decoding aliases that decrypt to the
+	// idents, which are only returned by the audited Reverse.
+	ErrDecodeIdents = errors.New("aliases decrypt to idents, use the reverse lookup")
+
 	// ErrBadDefName is returned when a user attempts to create a definition
 	// with a bad name.
 	ErrBadDefName = errors.New("name may only contain [A-Za-z0-9-_.] chars")
@@ -37,29 +58,16 @@ var (
 	// are made and all of the generated aliases already exist.
 	ErrMaxAttemptsReached = errors.New("max attempts reached")
 
+	// BatchSize is the number of identities sent to the store at once when
+	// generating, getting, putting, or deleting aliases.
+	BatchSize = 1000
+
 	// Def name validation regex.
 	nameRegex *regexp.Regexp
 	// Unused regex?
 	splitRegex *regexp.Regexp
-
-	// Prefix for internal use.
-	internalPrefix = "_:%s"
-
-	// Prefix for index definitions.
-	defPrefix   = "d:%s"
-	valuePrefix = "v:%d"
-	seqPrefix   = "s:%d"
-
-	// Prefix for keys, aliases, and sequences.
-	// These are scoped by the definition id.
-	keyPrefix   = "k:%d:%s"
-	aliasPrefix = "a:%d:%s"
 )
 
-func mk(f string, v ...interface{}) string {
-	return fmt.Sprintf(f, v...)
-}
-
 func init() {
 	nameRegex = regexp.MustCompile(`^[A-Za-z0-9-_\.]+$`)
 	splitRegex = regexp.MustCompile(`[\s,\t]+`)
@@ -108,76 +116,79 @@ type Server struct {
 	RedisPass string
 	RedisTLS  bool
 
-	Log  *log.Logger
-	Pool *redis.Pool
-}
+	Log   *log.Logger
+	Store Store
 
-func (s *Server) handleClose(c io.Closer) {
-	err := c.Close()
-	if err != nil {
-		s.Log.Printf("close error: %s\n", err)
-	}
+	// Keys holds the secrets of keyed generators by name.
+	Keys Keyring
+
+	// Purger removes archived definitions. It is nil if purging is disabled.
+	Purger *Purger
+
+	// IdentKey is the name of the keyring secret idents of new definitions
+	// are hashed with unless they set their own.
+	IdentKey string
+
+	// Audit records reverse lookups. They are disabled if it is nil.
+	Audit *AuditLog
+
+	// GrowRate is the collision rate of generated rand aliases above which
+	// the min length of the definition is increased. Zero disables growth.
+	GrowRate float64
+
+	mu         sync.Mutex
+	collisions map[int]*collisionStats
+	growLocks  map[int]*sync.Mutex
+	attempts   map[int][]int64
 }
 
 // Close shuts down the server.
 func (s *Server) Close() {
-	if s.Pool != nil {
-		s.handleClose(s.Pool)
+	if s.Store != nil {
+		if err := s.Store.Close(); err != nil {
+			s.Log.Printf("close error: %s\n", err)
+		}
+	}
+
+	if s.Audit != nil {
+		if err := s.Audit.Close(); err != nil {
+			s.Log.Printf("close error: %s\n", err)
+		}
 	}
 }
 
-// Init initializes a new server.
+// Init initializes a new server. If no store is set, a Redis store is
+// created from the Redis options.
 func (s *Server) Init() {
 	s.Log = log.New(os.Stderr, "aliases: ", 0)
 
-	// Create a pool of Redis connections.
-	s.Pool = &redis.Pool{
-		Dial: func() (redis.Conn, error) {
-			return redis.Dial(
-				"tcp",
-				s.RedisAddr,
-				redis.DialDatabase(s.RedisDB),
-				redis.DialPassword(s.RedisPass),
-				redis.DialUseTLS(s.RedisTLS),
-			)
-		},
-		IdleTimeout: DefaultIdleTimeout,
-		MaxIdle:     DefaultMaxIdle,
+	if s.Store == nil {
+		s.Store = NewRedisStore(s.RedisAddr, s.RedisDB, s.RedisPass, s.RedisTLS, s.Log)
 	}
 }
 
-// GetDefs retrieves multiple existing alias generation definitions.
-func (s *Server) GetDefs() ([]json.RawMessage, error) {
-	conn := s.Pool.Get()
-	defer s.handleClose(conn)
-
-	// Keys of the definitions.
-	keys, err := redis.Strings(conn.Do("KEYS", "v:*"))
-	if err != nil {
-		return nil, err
-	}
-
-	if len(keys) == 0 {
-		return []json.RawMessage{}, nil
-	}
-
-	args := make([]interface{}, len(keys))
-	for i, k := range keys {
-		args[i] = k
-	}
-
-	vals, err := redis.Strings(conn.Do("MGET", args...))
-	if err != nil {
-		return nil, err
-	}
+// Def states for filtering definitions.
+const (
+	DefsAll      = ""
+	DefsActive   = "active"
+	DefsArchived = "archived"
+)
 
-	defs := make([]json.RawMessage, len(vals))
+// DefsQuery filters and paginates definitions.
+type DefsQuery struct {
+	// State is one of DefsAll, DefsActive, or DefsArchived.
+	State string
 
-	for i, val := range vals {
-		defs[i] = json.RawMessage(val)
-	}
+	// Offset and Limit of the page. A zero limit returns all definitions.
+	Offset int
+	Limit  int
+}
 
-	return defs, nil
+// GetDefs retrieves multiple existing alias generation definitions ordered
+// by ID. The total number of definitions matching the state is returned
+// along with the page.
+func (s *Server) GetDefs(q DefsQuery) ([]*Def, int, error) {
+	return s.Store.GetDefs(q)
 }
 
 // DelDef marks a index for deletion.
@@ -188,20 +199,11 @@ func (s *Server) DelDef(name string) error {
 	}
 
 	// Internally mark as deleted to be cleaned up.
+	now := time.Now().UTC()
 	def.Deleted = true
-	b, err := json.Marshal(def)
-	if err != nil {
-		return err
-	}
-
-	conn := s.Pool.Get()
-	defer s.handleClose(conn)
+	def.ArchivedAt = &now
 
-	// Delete name entry to make inaccessable and update definition.
-	conn.Send("MULTI")
-	conn.Send("DEL", mk(defPrefix, name))
-	conn.Send("SET", mk(valuePrefix, def.ID), string(b))
-	if _, err := conn.Do("EXEC"); err != nil {
+	if err := s.Store.DelDef(def); err != nil {
 		return err
 	}
 
@@ -210,33 +212,72 @@ func (s *Server) DelDef(name string) error {
 	return nil
 }
 
-// GetDef retrieves an existing alias generation definition.
-func (s *Server) GetDef(name string) (*Def, error) {
-	conn := s.Pool.Get()
-	defer s.handleClose(conn)
+// RestoreDef restores an archived definition by name. If multiple archived
+// definitions have the name, the most recently created one is restored unless
+// a non-zero id is given. ErrDefExists is returned if the name has since been
+// taken by another definition.
+func (s *Server) RestoreDef(name string, id int) (*Def, error) {
+	var def *Def
 
-	id, err := redis.Int64(conn.Do("GET", mk(defPrefix, name)))
-	if err == redis.ErrNil {
-		return nil, ErrNoDef
-	} else if err != nil {
-		return nil, err
+	if id > 0 {
+		d, err := s.Store.GetDefByID(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if d.Name != name {
+			return nil, ErrNoDef
+		}
+
+		def = d
+	} else {
+		defs, _, err := s.Store.GetDefs(DefsQuery{State: DefsArchived})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range defs {
+			if d.Name == name && d.Deleted {
+				def = d
+			}
+		}
+
+		if def == nil {
+			return nil, ErrNoDef
+		}
 	}
 
-	blob, err := redis.Bytes(conn.Do("GET", mk(valuePrefix, id)))
-	if err != nil {
-		return nil, err
+	if !def.Deleted {
+		return nil, ErrDefNotArchived
+	}
+
+	def.Deleted = false
+	def.ArchivedAt = nil
+
+	restore := func() error {
+		return s.Store.RestoreDef(def)
 	}
 
-	if blob == nil {
-		panic(fmt.Sprintf("missing def value for %s", name))
+	var err error
+
+	if s.Purger != nil {
+		err = s.Purger.Restore(def.ID, restore)
+	} else {
+		err = restore()
 	}
 
-	var g Def
-	if err := json.Unmarshal(blob, &g); err != nil {
+	if err != nil {
 		return nil, err
 	}
 
-	return &g, nil
+	s.Log.Printf("restored '%s' (id=%d)", def.Name, def.ID)
+
+	return def, nil
+}
+
+// GetDef retrieves an existing alias generation definition.
+func (s *Server) GetDef(name string) (*Def, error) {
+	return s.Store.GetDef(name)
 }
 
 func (s *Server) validateDef(def *Def) error {
@@ -254,6 +295,42 @@ func (s *Server) validateDef(def *Def) error {
 
 	switch def.Type {
 	case "seq":
+		if def.Step < 0 {
+			return errors.New("seq step must be positive")
+		}
+
+		if def.Width < 0 || def.Width > MaxSeqWidth {
+			return fmt.Errorf("seq width must be between 0 and %d", MaxSeqWidth)
+		}
+	case "hashid":
+		if def.Offset < 0 {
+			return errors.New("hashid offset must not be negative")
+		}
+
+		if def.Step < 0 {
+			return errors.New("hashid step must be positive")
+		}
+
+		if def.Minlen < 0 {
+			return errors.New("hashid min length must not be negative")
+		}
+
+		if len(def.Chars) < MinRandChars {
+			return errors.New("too few chars for hashid")
+		}
+
+		if def.Key == "" {
+			return errors.New("hashid key required")
+		}
+
+		if _, err := s.Keys.Get(def.Key); err != nil {
+			return fmt.Errorf("unknown key '%s'", def.Key)
+		}
+
+		// Checks the alphabet.
+		if _, err := NewHashidGen(nil, def.Chars, nil, def.Minlen, def.Prefix); err != nil {
+			return fmt.Errorf("invalid hashid def: %s", err)
+		}
 	case "rand":
 		if def.Minlen < MinRandMinlen {
 			return errors.New("rand min length too small")
@@ -262,104 +339,232 @@ func (s *Server) validateDef(def *Def) error {
 		if len(def.Chars) < MinRandChars {
 			return errors.New("too few chars for rand")
 		}
+	case "hmac":
+		if def.Minlen < MinRandMinlen {
+			return errors.New("hmac min length too small")
+		}
+
+		if len(def.Chars) < MinRandChars {
+			return errors.New("too few chars for hmac")
+		}
+
+		if len(def.Chars) > 256 {
+			return errors.New("too many chars for hmac")
+		}
+
+		if def.Key == "" {
+			return errors.New("hmac key required")
+		}
+
+		if _, err := s.Keys.Get(def.Key); err != nil {
+			return fmt.Errorf("unknown key '%s'", def.Key)
+		}
+	case "fpe":
+		if def.Key == "" {
+			return errors.New("fpe key required")
+		}
+
+		key, err := s.Keys.Get(def.Key)
+		if err != nil {
+			return fmt.Errorf("unknown key '%s'", def.Key)
+		}
+
+		tweak, err := hex.DecodeString(def.Tweak)
+		if err != nil {
+			return errors.New("fpe tweak must be hex-encoded")
+		}
+
+		// Checks the key size, alphabet, and tweak.
+		if _, err := NewFPEGen(key, def.Chars, tweak); err != nil {
+			return fmt.Errorf("invalid fpe def: %s", err)
+		}
+	case "words":
+		if def.Words < 0 || def.Words > MaxWords {
+			return fmt.Errorf("words must be between 1 and %d", MaxWords)
+		}
+
+		if def.Digits < 0 || def.Digits > MaxWordsDigits {
+			return fmt.Errorf("words digits must be between 0 and %d", MaxWordsDigits)
+		}
+
+		if strings.ContainsAny(def.Separator, "abcdefghijklmnopqrstuvwxyz0123456789 \t\r\n") {
+			return errors.New("words separator may not contain lowercase letters, digits, or whitespace")
+		}
+
+		if e := newWordsGen(def).Entropy(); e < MinWordsEntropy {
+			return fmt.Errorf("words entropy too small: %.1f bits, at least %.0f required", e, MinWordsEntropy)
+		}
+	case "pattern":
+		if def.Pattern == "" {
+			return errors.New("pattern required")
+		}
+
+		parts, err := ParsePattern(def.Pattern, def.Chars)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %s", err)
+		}
+
+		gen := &PatternGen{parts: parts}
+
+		// Aliases with a sequence are unique regardless of the random chars.
+		if !gen.hasSeq() && gen.Space().Cmp(MinPatternSpace) < 0 {
+			return fmt.Errorf("pattern space too small: %s aliases, at least %s required", gen.Space(), MinPatternSpace)
+		}
+
+		if def.Step < 0 {
+			return errors.New("pattern step must be positive")
+		}
+	case "uuidv5":
+		if def.Namespace == "" {
+			return errors.New("uuidv5 namespace required")
+		}
+
+		if _, err := parseNamespace(def.Namespace); err != nil {
+			return fmt.Errorf("invalid uuidv5 namespace: %s", err)
+		}
+
+		if strings.ContainsAny(def.Prefix, " \t\r\n") {
+			return errors.New("prefix may not contain whitespace")
+		}
 	case "uuid":
+	case "ulid", "uuidv7":
+		if strings.ContainsAny(def.Prefix, " \t\r\n") {
+			return errors.New("prefix may not contain whitespace")
+		}
 	default:
 		return errors.New("unknown type")
 	}
 
-	return nil
-}
+	switch def.Type {
+	case "rand", "words", "pattern":
+		if _, err := NewSource(def); err != nil {
+			return err
+		}
+	default:
+		if def.Source != "" {
+			return fmt.Errorf("source is not supported by %s", def.Type)
+		}
+	}
 
-// CreateDef creates a new index for generating aliases.
-// d:foo -> 0
-// v:0 -> { ... }
-func (s *Server) CreateDef(def *Def) error {
-	if err := s.validateDef(def); err != nil {
+	if _, err := NewChecker(def); err != nil {
 		return err
 	}
 
-	// Check if there is an existing definition.
-	conn := s.Pool.Get()
-	defer s.handleClose(conn)
+	if err := validateBlocklist(def); err != nil {
+		return err
+	}
 
-	// Lookup up def by name.
-	defKey := mk(defPrefix, def.Name)
+	if def.IdentKey != "" {
+		if _, err := s.Keys.Get(def.IdentKey); err != nil {
+			return fmt.Errorf("unknown ident key '%s'", def.IdentKey)
+		}
 
-	exists, err := redis.Bool(conn.Do("EXISTS", defKey))
-	if err != nil {
-		return err
+		if def.Reverse {
+			return errors.New("reverse index is not supported with hashed idents")
+		}
 	}
 
-	// Cannot create a def by the same name.
-	if exists {
-		return ErrDefExists
+	return nil
+}
+
+// CreateDef creates a new index for generating aliases.
+func (s *Server) CreateDef(def *Def) error {
+	if def.Type == "fpe" && def.Tweak == "" {
+		tweak := make([]byte, DefaultFPETweakLen)
+		if _, err := crand.Read(tweak); err != nil {
+			return err
+		}
+		def.Tweak = hex.EncodeToString(tweak)
 	}
 
-	// Get a new key.
-	defIDKey := mk(internalPrefix, "def:id")
-	id, err := redis.Int64(conn.Do("INCR", defIDKey))
-	if err != nil {
+	if err := applyAlphabet(def); err != nil {
 		return err
 	}
 
-	def.ID = int(id)
+	if def.IdentKey == "" {
+		def.IdentKey = s.IdentKey
+	}
 
-	b, err := json.Marshal(def)
-	if err != nil {
+	if err := s.validateDef(def); err != nil {
 		return err
 	}
 
-	valueKey := mk(valuePrefix, def.ID)
-
-	args := []interface{}{
-		defKey, def.ID,
-		valueKey, string(b),
+	// Existing definitions may have a prefix their aliases were generated
+	// without, so it is only rejected on creation.
+	if def.Prefix != "" && def.aliasPrefix() == "" {
+		return fmt.Errorf("prefix does not apply to %s definitions", def.Type)
 	}
 
-	// Initialize the sequence.
-	if def.Type == "seq" {
-		seqKey := mk(seqPrefix, def.ID)
-		args = append(args, seqKey, def.Offset)
+	if err := validateChars(def); err != nil {
+		return err
 	}
 
-	_, err = conn.Do("MSET", args...)
-	if err != nil {
+	if err := s.Store.CreateDef(def); err != nil {
 		return err
 	}
 
 	s.Log.Printf("created def '%s' (id=%d)", def.Name, def.ID)
 
+	if def.Source == "math" {
+		s.Log.Printf("aliases of '%s' are predictable, use the math source for testing only", def.Name)
+	}
+
+	if gen, err := MakeGen(s.Store, s.Keys, def); err == nil {
+		if eg, ok := gen.(EntropyGen); ok {
+			s.Log.Printf("aliases of '%s' have %.1f bits of entropy", def.Name, eg.Entropy())
+		}
+
+		if sg, ok := gen.(SpaceGen); ok {
+			if space := sg.Space(); space != nil {
+				s.Log.Printf("'%s' can generate %s aliases", def.Name, space)
+			} else {
+				s.Log.Printf("'%s' can generate unbounded aliases", def.Name)
+			}
+		}
+	}
+
 	return nil
 }
 
 // UpdateDef updates an existing alias generation definition.
 func (s *Server) UpdateDef(name string, def *Def) error {
-	if err := s.validateDef(def); err != nil {
+	if err := applyAlphabet(def); err != nil {
 		return err
 	}
 
-	// Check if there is an existing definition.
-	conn := s.Pool.Get()
-	defer s.handleClose(conn)
-
-	b, err := json.Marshal(def)
+	cur, err := s.Store.GetDef(name)
 	if err != nil {
 		return err
 	}
 
-	// Delete previous definition.
-	if name != def.Name {
-		_, err = conn.Do("DEL", mk(defPrefix, name))
-		if err != nil {
+	if cur.Reverse != def.Reverse {
+		return ErrReverseChanged
+	}
+
+	if cur.IdentKey != def.IdentKey || cur.Migrating != def.Migrating {
+		return ErrIdentKeyChanged
+	}
+
+	if field := derivedChanged(cur, def); field != "" {
+		return fmt.Errorf("%s of %s definitions can only be set on creation", field, cur.Type)
+	}
+
+	if err := s.validateDef(def); err != nil {
+		return err
+	}
+
+	if def.Prefix != cur.Prefix && def.Prefix != "" && def.aliasPrefix() == "" {
+		return fmt.Errorf("prefix does not apply to %s definitions", def.Type)
+	}
+
+	// Definitions created before chars had to be unique can still be updated.
+	if def.Chars != cur.Chars {
+		if err := validateChars(def); err != nil {
 			return err
 		}
 	}
 
-	// Set name and value key.
-	defKey := mk(defPrefix, def.Name)
-	valueKey := mk(valuePrefix, def.ID)
-	_, err = conn.Do("MSET", defKey, def.ID, valueKey, string(b))
-	if err != nil {
+	if err := s.Store.UpdateDef(name, def); err != nil {
 		return err
 	}
 
@@ -368,77 +573,221 @@ func (s *Server) UpdateDef(name string, def *Def) error {
 	return nil
 }
 
+// derivedChanged returns the name of a field the existing aliases of the
+// definition are derived from that is changed by the update, or an empty
+// string. New aliases would not match the existing ones or could collide with
+// them. The sequence is initialized from the offset and step on creation.
+func derivedChanged(cur, def *Def) string {
+	if cur.Type != def.Type {
+		return "type"
+	}
+
+	if cur.Sequential() {
+		switch {
+		case cur.Offset != def.Offset:
+			return "offset"
+		case cur.Step != def.Step:
+			return "step"
+		}
+	}
+
+	switch cur.Type {
+	case "hashid":
+		switch {
+		case cur.Key != def.Key:
+			return "key"
+		case cur.Chars != def.Chars:
+			return "chars"
+		case cur.Minlen != def.Minlen:
+			return "minlen"
+		}
+
+	case "fpe":
+		switch {
+		case cur.Key != def.Key:
+			return "key"
+		case cur.Tweak != def.Tweak:
+			return "tweak"
+		case cur.Chars != def.Chars:
+			return "chars"
+		}
+
+	case "hmac":
+		switch {
+		case cur.Key != def.Key:
+			return "key"
+		case cur.Name != def.Name:
+			return "name"
+		}
+
+	case "uuidv5":
+		if cur.Namespace != def.Namespace {
+			return "namespace"
+		}
+	}
+
+	return ""
+}
+
+// chunks calls fn with consecutive slices of at most BatchSize idents.
+func chunks(n int, fn func(i, j int) error) error {
+	for i := 0; i < n; i += BatchSize {
+		j := i + BatchSize
+		if j > n {
+			j = n
+		}
+
+		if err := fn(i, j); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Gen generates a new alias for a slice of identities, given an existing definition.
-// It will keep trying to find a new, unused, alias for MaxAttempts before
-// returning ErrMaxAttemptsReached.
+// The identities are processed in batches of BatchSize: the existing aliases
+// are looked up, candidates are generated for the misses, and the candidates
+// are claimed together. Candidates that are taken are regenerated for
+// MaxAttempts before returning ErrMaxAttemptsReached.
 func (s *Server) Gen(def *Def, idents []*IdentAlias) ([]*IdentAlias, error) {
-	conn := s.Pool.Get()
-	defer s.handleClose(conn)
-
 	// Generator for this line.
-	gen := MakeGen(conn, def)
+	gen, err := MakeGen(s.Store, s.Keys, def)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, ia := range idents {
-		if ia.Ident == "" {
-			continue
-		}
+	check, err := NewChecker(def)
+	if err != nil {
+		return nil, err
+	}
 
-		lookupKey := mk(keyPrefix, def.ID, ia.Ident)
+	block := NewBlocklist(def)
 
-		// Check if the key already exists. If so, just return it.
-		alias, err := redis.String(conn.Do("GET", lookupKey))
+	st, err := s.identStore(def)
+	if err != nil {
+		return nil, err
+	}
 
-		// Exists.
-		if err == nil {
-			ia.Alias = alias
-			ia.Status = StatusExists
-			continue
+	err = chunks(len(idents), func(i, j int) error {
+		batch := make([]*IdentAlias, 0, j-i)
+
+		for _, ia := range idents[i:j] {
+			if ia.Ident != "" {
+				batch = append(batch, ia)
+			}
 		}
 
-		if err != nil && err != redis.ErrNil {
-			return nil, err
+		// Check if the keys already exist.
+		if err := st.Lookup(def, batch); err != nil {
+			return err
 		}
 
-		var attempt int
+		var misses []*IdentAlias
+
+		for _, ia := range batch {
+			if ia.Status == StatusMissing {
+				misses = append(misses, ia)
+			}
+		}
 
-		for {
+		// Attempts since the last growth and in total.
+		var attempt, tries int
+
+		for len(misses) > 0 {
 			if attempt == MaxAttempts {
-				s.Log.Printf("max attempts reached for '%s' in '%s'", lookupKey, def.Name)
-				// TODO: auto-increase minlenth if this occurs.
-				return nil, ErrMaxAttemptsReached
+				s.Log.Printf("max attempts reached for %d keys in '%s'", len(misses), def.Name)
+
+				if !s.growable(def) {
+					return ErrMaxAttemptsReached
+				}
+
+				g, err := s.growGen(def, "max attempts reached")
+				if err != nil {
+					return err
+				}
+
+				gen = g
+				attempt = 0
+			}
+
+			// Generate new keys.
+			aliases, err := genFor(gen, misses, attempt)
+			if err != nil {
+				return err
+			}
+
+			if check != nil {
+				for k, alias := range aliases {
+					if aliases[k], err = appendCheck(check, def.aliasPrefix(), alias); err != nil {
+						return err
+					}
+				}
 			}
 
 			attempt++
+			tries++
 
-			// Generate new key.
-			alias, err = gen.New()
-			if err != nil {
-				return nil, err
+			// Candidates containing blocked substrings are regenerated
+			// without claiming them.
+			var claim, blocked []*IdentAlias
+
+			for k, ia := range misses {
+				ia.Alias = aliases[k]
+				ia.Status = 0
+
+				if block != nil && block.Match(strings.TrimPrefix(ia.Alias, def.aliasPrefix())) {
+					blocked = append(blocked, ia)
+				} else {
+					claim = append(claim, ia)
+				}
 			}
 
-			// Check if it exists, otherwise set it.
-			checkKey := mk(aliasPrefix, def.ID, alias)
+			if len(blocked) > 0 {
+				blockedAliases.Add(def.Name, int64(len(blocked)))
+			}
 
-			ok, err := redis.Bool(conn.Do("EXISTS", checkKey))
-			if err != nil {
-				return nil, err
+			// Set them unless the aliases are already taken.
+			if len(claim) > 0 {
+				if err := st.Claim(def, claim); err != nil {
+					return err
+				}
 			}
 
-			// Does not exist, set it.
-			if !ok {
-				_, err := conn.Do("MSET", lookupKey, alias, checkKey, true)
-				if err != nil {
-					return nil, err
+			// Retry the ones that were blocked or taken.
+			taken := blocked
+			created := 0
+
+			for _, ia := range claim {
+				switch ia.Status {
+				case 0:
+					taken = append(taken, ia)
+				case StatusCreated:
+					created++
 				}
+			}
 
-				ia.Alias = alias
-				ia.Status = StatusCreated
+			s.recordAttempts(def, tries, created)
 
-				// TODO: add metric for number of attempts. this is an indicator
-				// to whether the min length should be increased.
-				break
+			// A high collision rate indicates the alias space is filling up,
+			// so longer aliases are generated before attempts run out.
+			if s.recordCollisions(def, len(claim), len(taken)-len(blocked)) {
+				g, err := s.growGen(def, "collision rate exceeded")
+				if err != nil {
+					return err
+				}
+
+				gen = g
 			}
+
+			misses = taken
 		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
 	return idents, nil
@@ -446,36 +795,179 @@ func (s *Server) Gen(def *Def, idents []*IdentAlias) ([]*IdentAlias, error) {
 
 // Get retrieves existing aliases for a slice of identities in a given alias definition.
 func (s *Server) Get(def *Def, idents []*IdentAlias) ([]*IdentAlias, error) {
-	conn := s.Pool.Get()
-	defer s.handleClose(conn)
+	st, err := s.identStore(def)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, ia := range idents {
-		lookupKey := mk(keyPrefix, def.ID, ia.Ident)
+	err = chunks(len(idents), func(i, j int) error {
+		return st.Lookup(def, idents[i:j])
+	})
 
-		// Check if the key already exists. If so, just return it.
-		alias, err := redis.String(conn.Do("GET", lookupKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return idents, nil
+}
 
-		// Exists.
-		if err == nil {
-			ia.Alias = alias
+// Decode converts aliases back to the sequence number they were generated
+// from, if the generator of the definition supports it. Aliases that cannot be
+// decoded are marked as missing. Aliases that decrypt to the idents are only
+// reversed by Reverse, which records the lookup.
+func (s *Server) Decode(def *Def, aliases []string) ([]*IdentAlias, error) {
+	if def.Type == "fpe" {
+		return nil, ErrDecodeIdents
+	}
+
+	idents, err := s.decode(def, aliases)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Log.Printf("decoded %d aliases of '%s'", len(aliases), def.Name)
+
+	return idents, nil
+}
+
+// decode converts the aliases back with the generator of the definition.
+func (s *Server) decode(def *Def, aliases []string) ([]*IdentAlias, error) {
+	gen, err := MakeGen(s.Store, s.Keys, def)
+	if err != nil {
+		return nil, err
+	}
+
+	rg, ok := gen.(ReverseGen)
+	if !ok {
+		return nil, ErrNotReversible
+	}
+
+	check, err := NewChecker(def)
+	if err != nil {
+		return nil, err
+	}
+
+	idents := make([]*IdentAlias, len(aliases))
+
+	for i, alias := range aliases {
+		alias = normalizeAlias(def, alias)
+
+		ia := &IdentAlias{Alias: alias}
+		idents[i] = ia
+
+		if check != nil {
+			var ok bool
+			if alias, ok = verifyCheck(check, def.aliasPrefix(), alias); !ok {
+				ia.Status = StatusMissing
+				continue
+			}
+		}
+
+		if ident, err := rg.Reverse(alias); err != nil {
+			ia.Status = StatusMissing
+		} else {
+			ia.Ident = ident
 			ia.Status = StatusExists
-			continue
 		}
+	}
 
-		if err != nil && err != redis.ErrNil {
-			return nil, err
+	return idents, nil
+}
+
+// Validate verifies the check char of each alias without looking it up.
+// ErrNoCheck is returned if the definition has no check char.
+func (s *Server) Validate(def *Def, aliases []string) ([]bool, error) {
+	check, err := NewChecker(def)
+	if err != nil {
+		return nil, err
+	}
+
+	if check == nil {
+		return nil, ErrNoCheck
+	}
+
+	valid := make([]bool, len(aliases))
+
+	for i, alias := range aliases {
+		_, valid[i] = verifyCheck(check, def.aliasPrefix(), normalizeAlias(def, alias))
+	}
+
+	return valid, nil
+}
+
+// Reverse looks up the idents of the aliases of a definition with a reverse
+// index, or decrypts those of an fpe definition. Every lookup is recorded in the audit log along with the reason and
+// the remote address of the requester, and no idents are returned if the
+// record cannot be written.
+func (s *Server) Reverse(def *Def, aliases []string, reason, remote string) ([]*IdentAlias, error) {
+	if !def.Reverse && def.Type != "fpe" {
+		return nil, ErrNoReverseIndex
+	}
+
+	if s.Audit == nil {
+		return nil, ErrNoAudit
+	}
+
+	if strings.TrimSpace(reason) == "" {
+		return nil, ErrNoReason
+	}
+
+	idents := make([]*IdentAlias, len(aliases))
+	normalized := make([]string, len(aliases))
+
+	for i, alias := range aliases {
+		normalized[i] = normalizeAlias(def, alias)
+		idents[i] = &IdentAlias{Alias: normalized[i]}
+	}
+
+	var err error
+
+	if def.Type == "fpe" {
+		idents, err = s.decode(def, normalized)
+	} else {
+		err = chunks(len(idents), func(i, j int) error {
+			return s.Store.Reverse(def, idents[i:j])
+		})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var found int
+
+	for _, ia := range idents {
+		if ia.Status == StatusExists {
+			found++
 		}
+	}
+
+	err = s.Audit.Write(&AuditRecord{
+		Time:    time.Now().UTC(),
+		Action:  "reverse",
+		DefID:   def.ID,
+		Def:     def.Name,
+		Reason:  reason,
+		Remote:  remote,
+		Aliases: normalized,
+		Found:   found,
+	})
 
-		ia.Status = StatusMissing
+	if err != nil {
+		s.Log.Printf("audit error: %s", err)
+		return nil, err
 	}
 
+	s.Log.Printf("reversed %d aliases of '%s' for %s", len(aliases), def.Name, remote)
+
 	return idents, nil
 }
 
-// Put explicitly sets a set of IDs with an alias.
+// Put explicitly sets a set of IDs with an alias. The idents are set in
+// batches of BatchSize, so if a batch fails, such as with ErrAliasExists, the
+// batches before it remain set.
 func (s *Server) Put(def *Def, idents []*IdentAlias) error {
-	conn := s.Pool.Get()
-	defer s.handleClose(conn)
+	batch := make([]*IdentAlias, 0, len(idents))
 
 	for _, ia := range idents {
 		if ia.Ident == "" {
@@ -486,15 +978,22 @@ func (s *Server) Put(def *Def, idents []*IdentAlias) error {
 			return errors.New("empty alias")
 		}
 
-		// key to alias
-		lookupKey := mk(keyPrefix, def.ID, ia.Ident)
-		// alias entry for existence check.
-		checkKey := mk(aliasPrefix, def.ID, ia.Alias)
+		ia.Alias = normalizeAlias(def, ia.Alias)
 
-		_, err := conn.Do("MSET", lookupKey, ia.Alias, checkKey, true)
-		if err != nil {
-			return err
-		}
+		batch = append(batch, ia)
+	}
+
+	st, err := s.identStore(def)
+	if err != nil {
+		return err
+	}
+
+	err = chunks(len(batch), func(i, j int) error {
+		return st.Set(def, batch[i:j])
+	})
+
+	if err != nil {
+		return err
 	}
 
 	s.Log.Printf("put %d keys", len(idents))
@@ -504,46 +1003,29 @@ func (s *Server) Put(def *Def, idents []*IdentAlias) error {
 
 // Del deletes a slice of identities from an alias generation definition.
 func (s *Server) Del(def *Def, idents []string) error {
-	conn := s.Pool.Get()
-	defer s.handleClose(conn)
-
 	var (
 		removedCount  int
-		skippedCount  int
 		conflictCount int
-		internalCount int
 	)
 
-	for _, ident := range idents {
-		lookupKey := mk(keyPrefix, def.ID, ident)
-
-		// Get the corresponding alias.
-		alias, err := redis.String(conn.Do("GET", lookupKey))
-		if err == redis.ErrNil {
-			skippedCount++
-			continue
-		}
-
-		if err != nil {
-			return err
-		}
-
-		removedCount++
-
-		checkKey := mk(aliasPrefix, def.ID, alias)
+	st, err := s.identStore(def)
+	if err != nil {
+		return err
+	}
 
-		n, err := redis.Int64(conn.Do("DEL", lookupKey, checkKey))
-		if err != nil {
-			return err
-		}
+	err = chunks(len(idents), func(i, j int) error {
+		n, err := st.Del(def, idents[i:j])
+		removedCount += n
+		return err
+	})
 
-		internalCount += int(n)
+	if err != nil {
+		return err
 	}
 
 	s.Log.Printf("%d removed", removedCount)
-	s.Log.Printf("%d skipped", skippedCount)
+	s.Log.Printf("%d skipped", len(idents)-removedCount)
 	s.Log.Printf("%d conflicts", conflictCount)
-	s.Log.Printf("%d internal", internalCount)
 
 	return nil
 }
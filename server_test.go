@@ -1,12 +1,34 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 )
 
 func initServer(t testing.TB) *Server {
+	s := &Server{
+		Store: NewMemoryStore(),
+	}
+	s.Init()
+
+	return s
+}
+
+// initRedisServer returns a server backed by the Redis database set in
+// REDIS_ADDR and REDIS_DB. The database is flushed, so the test is
+// skipped unless it is set explicitly.
+func initRedisServer(t testing.TB) *Server {
+	if os.Getenv("REDIS_ADDR") == "" {
+		t.Skip("REDIS_ADDR not set")
+	}
+
 	var (
 		db  int
 		err error
@@ -26,7 +48,7 @@ func initServer(t testing.TB) *Server {
 	s.Init()
 
 	// Flush the DB.
-	c := s.Pool.Get()
+	c := s.Store.(*RedisStore).Pool.Get()
 	defer c.Close()
 	if _, err = c.Do("FLUSHDB"); err != nil {
 		t.Fatal(err)
@@ -35,9 +57,7 @@ func initServer(t testing.TB) *Server {
 	return s
 }
 
-func TestServer(t *testing.T) {
-	s := initServer(t)
-
+func testServer(t *testing.T, s *Server) {
 	n := "test"
 
 	def := NewDef()
@@ -63,10 +83,32 @@ func TestServer(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	for _, ia := range idents {
+	for i, ia := range idents {
 		if ia.Alias == "" || ia.Status != StatusCreated {
 			t.Errorf("%s alias failed", ia.Ident)
 		}
+
+		if exp := strconv.Itoa(100000 + i); ia.Alias != exp {
+			t.Errorf("expected %s to start at the offset, got %s", exp, ia.Alias)
+		}
+	}
+
+	// The sequence is initialized on creation.
+	for _, update := range []func(*Def){
+		func(d *Def) { d.Offset = 1 },
+		func(d *Def) { d.Step = 2 },
+		func(d *Def) { d.Type = "hashid" },
+	} {
+		d, err := s.GetDef(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		update(d)
+
+		if err := s.UpdateDef(n, d); err == nil {
+			t.Errorf("expected error updating %+v", d)
+		}
 	}
 
 	idents, err = s.Get(def, idents)
@@ -80,3 +122,1425 @@ func TestServer(t *testing.T) {
 		}
 	}
 }
+
+func TestServer(t *testing.T) {
+	testServer(t, initServer(t))
+}
+
+func TestServerRedis(t *testing.T) {
+	testServer(t, initRedisServer(t))
+}
+
+func testServerBatch(t *testing.T, s *Server) {
+	defer func(n int) { BatchSize = n }(BatchSize)
+	BatchSize = 7
+
+	def := NewDef()
+	def.Name = "batch"
+	def.Type = "rand"
+	def.Minlen = MinRandMinlen
+	def.Chars = "abcdefgh"
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	var idents []*IdentAlias
+	for i := 0; i < 50; i++ {
+		idents = append(idents, &IdentAlias{Ident: fmt.Sprint(i)})
+	}
+
+	// Duplicate and empty idents.
+	idents = append(idents, &IdentAlias{Ident: "3"}, &IdentAlias{})
+
+	idents, err := s.Gen(def, idents)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliases := make(map[string]string)
+
+	for i, ia := range idents[:50] {
+		if ia.Ident != fmt.Sprint(i) {
+			t.Fatalf("expected order to be preserved, got %s at %d", ia.Ident, i)
+		}
+
+		if ia.Status != StatusCreated {
+			t.Errorf("expected %s to be created, got %s", ia.Ident, ia.Status)
+		}
+
+		if ident, ok := aliases[ia.Alias]; ok {
+			t.Errorf("alias %s assigned to %s and %s", ia.Alias, ident, ia.Ident)
+		}
+		aliases[ia.Alias] = ia.Ident
+	}
+
+	if ia := idents[50]; ia.Status != StatusExists || ia.Alias != idents[3].Alias {
+		t.Errorf("expected duplicate to exist, got %+v", ia)
+	}
+
+	if ia := idents[51]; ia.Status != 0 || ia.Alias != "" {
+		t.Errorf("expected empty ident to be skipped, got %+v", ia)
+	}
+
+	// Idempotent.
+	again := []*IdentAlias{{Ident: "10"}, {Ident: "new"}}
+	if _, err := s.Gen(def, again); err != nil {
+		t.Fatal(err)
+	}
+
+	if again[0].Status != StatusExists || again[0].Alias != idents[10].Alias {
+		t.Errorf("expected existing alias, got %+v", again[0])
+	}
+
+	if again[1].Status != StatusCreated {
+		t.Errorf("expected new alias, got %+v", again[1])
+	}
+
+	if err := s.Del(def, []string{"1", "2", "missing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Get(def, []*IdentAlias{{Ident: "1"}, {Ident: "0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got[0].Status != StatusMissing || got[1].Status != StatusExists {
+		t.Errorf("expected deleted ident to be missing, got %+v %+v", got[0], got[1])
+	}
+}
+
+func TestServerBatch(t *testing.T) {
+	testServerBatch(t, initServer(t))
+}
+
+func TestServerBatchRedis(t *testing.T) {
+	testServerBatch(t, initRedisServer(t))
+}
+
+func TestServerGetDefs(t *testing.T) {
+	s := initServer(t)
+
+	for i := 0; i < 5; i++ {
+		def := NewDef()
+		def.Name = fmt.Sprintf("def%d", i)
+		def.Type = "uuid"
+
+		if err := s.CreateDef(def); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"def1", "def3"} {
+		if err := s.DelDef(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		q     DefsQuery
+		names []string
+		total int
+	}{
+		{DefsQuery{}, []string{"def0", "def1", "def2", "def3", "def4"}, 5},
+		{DefsQuery{State: DefsActive}, []string{"def0", "def2", "def4"}, 3},
+		{DefsQuery{State: DefsArchived}, []string{"def1", "def3"}, 2},
+		{DefsQuery{Offset: 1, Limit: 2}, []string{"def1", "def2"}, 5},
+		{DefsQuery{State: DefsActive, Offset: 2, Limit: 2}, []string{"def4"}, 3},
+		{DefsQuery{Offset: 10}, []string{}, 5},
+	}
+
+	for _, test := range tests {
+		defs, total, err := s.GetDefs(test.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names := []string{}
+		for _, def := range defs {
+			names = append(names, def.Name)
+		}
+
+		if fmt.Sprint(names) != fmt.Sprint(test.names) || total != test.total {
+			t.Errorf("%+v: expected %v (%d), got %v (%d)", test.q, test.names, test.total, names, total)
+		}
+	}
+}
+
+func TestServerRestoreDef(t *testing.T) {
+	s := initServer(t)
+
+	def := NewDef()
+	def.Name = "a"
+	def.Type = "rand"
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	idents, err := s.Gen(def, []*IdentAlias{{Ident: "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.RestoreDef("a", 0); err != ErrNoDef {
+		t.Errorf("expected ErrNoDef for active def, got %v", err)
+	}
+
+	if _, err := s.RestoreDef("a", def.ID); err != ErrDefNotArchived {
+		t.Errorf("expected ErrDefNotArchived, got %v", err)
+	}
+
+	if err := s.DelDef("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := s.RestoreDef("a", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.ID != def.ID || restored.Deleted || restored.ArchivedAt != nil {
+		t.Errorf("expected restored def, got %+v", restored)
+	}
+
+	got, err := s.Get(restored, []*IdentAlias{{Ident: "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got[0].Alias != idents[0].Alias {
+		t.Errorf("expected alias to be kept, got %s", got[0].Alias)
+	}
+
+	// Archive and take the name.
+	if err := s.DelDef("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	taken := NewDef()
+	taken.Name = "a"
+	taken.Type = "uuid"
+
+	if err := s.CreateDef(taken); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.RestoreDef("a", def.ID); err != ErrDefExists {
+		t.Errorf("expected ErrDefExists, got %v", err)
+	}
+
+	// The most recent archived def is restored by default.
+	if err := s.DelDef("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err = s.RestoreDef("a", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.ID != taken.ID {
+		t.Errorf("expected def %d to be restored, got %d", taken.ID, restored.ID)
+	}
+}
+
+func TestServerHMAC(t *testing.T) {
+	keys := Keyring{"site": bytes.Repeat([]byte{1}, 32)}
+
+	newDef := func() *Def {
+		def := NewDef()
+		def.Name = "hmac"
+		def.Type = "hmac"
+		def.Key = "site"
+		return def
+	}
+
+	idents := func() []*IdentAlias {
+		return []*IdentAlias{{Ident: "1"}, {Ident: "2"}, {Ident: "3"}}
+	}
+
+	// Disconnected servers sharing the secret.
+	var results [2][]*IdentAlias
+
+	for i := range results {
+		s := initServer(t)
+		s.Keys = keys
+
+		def := newDef()
+		if err := s.CreateDef(def); err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := s.Gen(def, idents())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results[i] = res
+	}
+
+	for i, ia := range results[0] {
+		if ia.Status != StatusCreated || ia.Alias != results[1][i].Alias {
+			t.Errorf("expected %s to have the same alias, got %s and %s", ia.Ident, ia.Alias, results[1][i].Alias)
+		}
+	}
+
+	// The derived alias of 2 is taken.
+	s := initServer(t)
+	s.Keys = keys
+
+	def := newDef()
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	taken := []*IdentAlias{{Ident: "x", Alias: results[0][1].Alias}}
+	if err := s.Put(def, taken); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.Gen(def, idents())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a := res[1].Alias; len(a) != def.Minlen+1 || !strings.HasPrefix(a, results[0][1].Alias) {
+		t.Errorf("expected a longer alias, got %s", a)
+	}
+
+	if res[0].Alias != results[0][0].Alias {
+		t.Errorf("expected %s, got %s", results[0][0].Alias, res[0].Alias)
+	}
+
+	// The key cannot be changed.
+	def.Key = "other"
+
+	if err := s.UpdateDef(def.Name, def); err == nil {
+		t.Error("expected error changing the key")
+	}
+
+	// Unknown keys are rejected.
+	def = newDef()
+	def.Name = "other"
+	def.Key = "missing"
+
+	if err := s.CreateDef(def); err == nil {
+		t.Error("expected error for unknown key")
+	}
+}
+
+func TestServerFPE(t *testing.T) {
+	s := initServer(t)
+	s.Keys = Keyring{"site": bytes.Repeat([]byte{1}, 16)}
+
+	def := NewDef()
+	def.Name = "mrn"
+	def.Type = "fpe"
+	def.Key = "site"
+	def.Chars = "0123456789"
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(def.Tweak) != DefaultFPETweakLen*2 {
+		t.Errorf("expected a generated tweak, got %q", def.Tweak)
+	}
+
+	idents, err := s.Gen(def, []*IdentAlias{{Ident: "00012345"}, {Ident: "98765432"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := MakeGen(s.Store, s.Keys, def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ia := range idents {
+		if ia.Status != StatusCreated || len(ia.Alias) != len(ia.Ident) || ia.Alias == ia.Ident {
+			t.Errorf("unexpected alias %s for %s", ia.Alias, ia.Ident)
+		}
+
+		ident, err := g.(ReverseGen).Reverse(ia.Alias)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ident != ia.Ident {
+			t.Errorf("expected %s to reverse to %s, got %s", ia.Alias, ia.Ident, ident)
+		}
+	}
+
+	_, err = s.Gen(def, []*IdentAlias{{Ident: "0001234x"}})
+	if _, ok := err.(*IdentError); !ok {
+		t.Errorf("expected ident error, got %v", err)
+	}
+
+	// Idents are only decrypted by the audited reverse lookup.
+	aliases := []string{idents[0].Alias, idents[1].Alias}
+
+	if _, err := s.Decode(def, aliases); err != ErrDecodeIdents {
+		t.Errorf("expected ErrDecodeIdents, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	if s.Audit, err = OpenAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Audit.Close()
+
+	reversed, err := s.Reverse(def, aliases, "irb-42", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, ia := range reversed {
+		if ia.Status != StatusExists || ia.Ident != idents[i].Ident {
+			t.Errorf("expected %s to reverse to %s, got %s", ia.Alias, idents[i].Ident, ia.Ident)
+		}
+	}
+
+	if b, _ := ioutil.ReadFile(path); !bytes.Contains(b, []byte("irb-42")) {
+		t.Error("expected the lookup to be audited")
+	}
+
+	// The fields the aliases are encrypted with cannot be changed.
+	for _, update := range []func(*Def){
+		func(d *Def) { d.Key = "other" },
+		func(d *Def) { d.Tweak = "00000000" },
+		func(d *Def) { d.Chars = "0123456789abcdef" },
+	} {
+		d, err := s.GetDef(def.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		update(d)
+
+		if err := s.UpdateDef(def.Name, d); err == nil {
+			t.Errorf("expected error updating %+v", d)
+		}
+	}
+
+	// Chars must be unique.
+	def = NewDef()
+	def.Name = "bad"
+	def.Type = "fpe"
+	def.Key = "site"
+	def.Chars = "01234567890"
+
+	if err := s.CreateDef(def); err == nil {
+		t.Error("expected error for duplicate chars")
+	}
+}
+
+func TestServerHashid(t *testing.T) {
+	s := initServer(t)
+	s.Keys = Keyring{"site": bytes.Repeat([]byte{1}, 16)}
+
+	def := NewDef()
+	def.Name = "public"
+	def.Type = "hashid"
+	def.Key = "site"
+	def.Chars = "abcdefghijklmnopqrstuvwxyz0123456789"
+	def.Minlen = 6
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	idents, err := s.Gen(def, []*IdentAlias{{Ident: "a"}, {Ident: "b"}, {Ident: "c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliases := make([]string, len(idents))
+
+	for i, ia := range idents {
+		if ia.Status != StatusCreated || len(ia.Alias) != 6 {
+			t.Errorf("unexpected alias %s for %s", ia.Alias, ia.Ident)
+		}
+		aliases[i] = ia.Alias
+	}
+
+	decoded, err := s.Decode(def, append(aliases, "nope"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, ia := range decoded[:3] {
+		if exp := strconv.Itoa(i + 1); ia.Status != StatusExists || ia.Ident != exp {
+			t.Errorf("expected %s to decode to %s, got %s", ia.Alias, exp, ia.Ident)
+		}
+	}
+
+	if decoded[3].Status != StatusMissing {
+		t.Error("expected invalid alias to be missing")
+	}
+
+	// The fields the codes are derived from cannot be changed.
+	for _, update := range []func(*Def){
+		func(d *Def) { d.Key = "other" },
+		func(d *Def) { d.Chars = "0123456789abcdef" },
+		func(d *Def) { d.Minlen = 8 },
+	} {
+		d, err := s.GetDef(def.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		update(d)
+
+		if err := s.UpdateDef(def.Name, d); err == nil {
+			t.Errorf("expected error updating %+v", d)
+		}
+	}
+
+	def.Block = []string{"abc"}
+
+	if err := s.UpdateDef(def.Name, def); err != nil {
+		t.Errorf("expected other fields to be updated, got %v", err)
+	}
+
+	// Other types cannot be decoded.
+	rand := NewDef()
+	rand.Name = "rand"
+	rand.Type = "rand"
+
+	if err := s.CreateDef(rand); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Decode(rand, aliases); err != ErrNotReversible {
+		t.Errorf("expected ErrNotReversible, got %v", err)
+	}
+}
+
+func TestServerUUIDv5(t *testing.T) {
+	newDef := func() *Def {
+		def := NewDef()
+		def.Name = "v5"
+		def.Type = "uuidv5"
+		def.Namespace = "0b2e1c6a-4d3f-4b8e-9a57-4c1d2e3f4a5b"
+		return def
+	}
+
+	idents := func() []*IdentAlias {
+		return []*IdentAlias{{Ident: "1"}, {Ident: "2"}}
+	}
+
+	// Rebuilt from scratch.
+	var results [2][]*IdentAlias
+
+	for i := range results {
+		s := initServer(t)
+
+		def := newDef()
+		if err := s.CreateDef(def); err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := s.Gen(def, idents())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results[i] = res
+
+		// The mapping is recorded.
+		res, err = s.Get(def, idents())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for j, ia := range res {
+			if ia.Status != StatusExists || ia.Alias != results[i][j].Alias {
+				t.Errorf("expected %s to exist", ia.Ident)
+			}
+		}
+	}
+
+	for i, ia := range results[0] {
+		if ia.Status != StatusCreated || ia.Alias != results[1][i].Alias {
+			t.Errorf("expected %s to have the same alias, got %s and %s", ia.Ident, ia.Alias, results[1][i].Alias)
+		}
+	}
+
+	s := initServer(t)
+
+	def := newDef()
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	// The namespace cannot be changed.
+	def.Namespace = "dns"
+
+	if err := s.UpdateDef(def.Name, def); err == nil {
+		t.Error("expected error changing the namespace")
+	}
+
+	def = newDef()
+	def.Name = "other"
+	def.Namespace = ""
+
+	if err := s.CreateDef(def); err == nil {
+		t.Error("expected error without namespace")
+	}
+}
+
+func TestServerCheck(t *testing.T) {
+	s := initServer(t)
+
+	def := NewDef()
+	def.Name = "checked"
+	def.Type = "seq"
+	def.Prefix = "S-"
+	def.Width = 4
+	def.Check = "verhoeff"
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	idents, err := s.Gen(def, []*IdentAlias{{Ident: "a"}, {Ident: "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Verhoeff check digits of 0001 and 0002.
+	if idents[0].Alias != "S-00011" || idents[1].Alias != "S-00024" {
+		t.Errorf("unexpected aliases %s and %s", idents[0].Alias, idents[1].Alias)
+	}
+
+	valid, err := s.Validate(def, []string{"S-00024", "S-00025", "S-00204", "00024"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := []bool{true, false, false, false}; fmt.Sprint(valid) != fmt.Sprint(exp) {
+		t.Errorf("expected %v, got %v", exp, valid)
+	}
+
+	def.Check = ""
+	if _, err := s.Validate(def, nil); err != ErrNoCheck {
+		t.Errorf("expected ErrNoCheck, got %v", err)
+	}
+
+	// Numeric checks require numeric aliases.
+	def = NewDef()
+	def.Name = "bad"
+	def.Type = "rand"
+	def.Check = "damm"
+
+	if err := s.CreateDef(def); err == nil {
+		t.Error("expected error for damm check of rand")
+	}
+}
+
+func TestServerCheckPrefix(t *testing.T) {
+	s := initServer(t)
+	s.Keys = Keyring{"site": bytes.Repeat([]byte{1}, 16)}
+
+	for _, typ := range []string{"seq", "rand", "hmac", "hashid", "fpe", "words", "pattern", "uuid", "uuidv5", "ulid", "uuidv7"} {
+		def := NewDef()
+		def.Name = typ
+		def.Type = typ
+		def.Prefix = "P-"
+		def.Check = "luhn"
+
+		switch typ {
+		case "hmac", "hashid":
+			def.Key = "site"
+		case "fpe":
+			def.Key = "site"
+			def.Chars = "0123456789"
+		case "words":
+			def.Words = 3
+		case "pattern":
+			def.Pattern = "@@-####"
+		case "uuidv5":
+			def.Namespace = "dns"
+		}
+
+		if def.aliasPrefix() == "" {
+			if err := s.CreateDef(def); err == nil {
+				t.Errorf("%s: expected the prefix to be rejected", typ)
+			}
+
+			def.Prefix = ""
+		}
+
+		if err := s.CreateDef(def); err != nil {
+			t.Errorf("%s: %s", typ, err)
+			continue
+		}
+
+		idents, err := s.Gen(def, []*IdentAlias{{Ident: "00012345"}})
+		if err != nil {
+			t.Errorf("%s: %s", typ, err)
+			continue
+		}
+
+		alias := idents[0].Alias
+
+		if !strings.HasPrefix(alias, def.Prefix) {
+			t.Errorf("%s: expected prefix, got %s", typ, alias)
+		}
+
+		valid, err := s.Validate(def, []string{alias})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !valid[0] {
+			t.Errorf("%s: expected %s to be valid", typ, alias)
+		}
+
+		if typ == "hashid" {
+			decoded, err := s.Decode(def, []string{alias})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if decoded[0].Status != StatusExists {
+				t.Errorf("%s: expected %s to decode", typ, alias)
+			}
+		}
+	}
+}
+
+func TestServerPrefixIgnored(t *testing.T) {
+	s := initServer(t)
+	s.Keys = Keyring{"site": bytes.Repeat([]byte{1}, 16)}
+
+	def := NewDef()
+	def.Name = "mrn"
+	def.Type = "fpe"
+	def.Key = "site"
+	def.Chars = "0123456789"
+	def.Check = "damm"
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	// Definitions created before the prefix was rejected keep it.
+	def.Prefix = "P-"
+
+	if err := s.Store.UpdateDef(def.Name, def); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.UpdateDef(def.Name, def); err != nil {
+		t.Fatal(err)
+	}
+
+	idents, err := s.Gen(def, []*IdentAlias{{Ident: "00012345"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alias := idents[0].Alias
+
+	if strings.HasPrefix(alias, "P-") {
+		t.Errorf("expected no prefix, got %s", alias)
+	}
+
+	if valid, err := s.Validate(def, []string{alias}); err != nil || !valid[0] {
+		t.Errorf("expected %s to be valid (%v)", alias, err)
+	}
+
+	def.Prefix = "Q-"
+
+	if err := s.UpdateDef(def.Name, def); err == nil {
+		t.Error("expected the prefix to be rejected")
+	}
+}
+
+func TestServerWordsValidation(t *testing.T) {
+	s := initServer(t)
+
+	tests := []struct {
+		words  int
+		digits int
+		sep    string
+		ok     bool
+	}{
+		{0, 0, "", true},
+		{2, 0, "", true},
+		{1, 3, ".", true},
+		{1, 0, "", false},
+		{1, 2, "", false},
+		{9, 0, "", false},
+		{3, 7, "", false},
+		{3, 0, "x", false},
+		{3, 0, " ", false},
+	}
+
+	for i, test := range tests {
+		def := NewDef()
+		def.Name = fmt.Sprintf("words%d", i)
+		def.Type = "words"
+		def.Words = test.words
+		def.Digits = test.digits
+		def.Separator = test.sep
+
+		err := s.CreateDef(def)
+
+		if test.ok && err != nil {
+			t.Errorf("%d: unexpected error %s", i, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%d: expected error", i)
+		}
+	}
+}
+
+func TestServerPattern(t *testing.T) {
+	s := initServer(t)
+
+	def := NewDef()
+	def.Name = "pattern"
+	def.Type = "pattern"
+	def.Pattern = "AB-####-@@"
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	idents, err := s.Gen(def, []*IdentAlias{{Ident: "1"}, {Ident: "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ia := range idents {
+		if ia.Status != StatusCreated || len(ia.Alias) != 10 {
+			t.Errorf("unexpected alias %s", ia.Alias)
+		}
+	}
+
+	// Too small without a sequence.
+	def = NewDef()
+	def.Name = "small"
+	def.Type = "pattern"
+	def.Pattern = "X-###"
+
+	if err := s.CreateDef(def); err == nil {
+		t.Error("expected error for small pattern space")
+	}
+
+	def.Pattern = "X-{seq:3}"
+
+	if err := s.CreateDef(def); err != nil {
+		t.Error(err)
+	}
+}
+
+// fillRandSpace sets every alias of the minlen of the rand definition.
+func fillRandSpace(t *testing.T, s *Server, def *Def) {
+	var idents []*IdentAlias
+
+	n := 1
+	for i := 0; i < def.Minlen; i++ {
+		n *= len(def.Chars)
+	}
+
+	for i := 0; i < n; i++ {
+		b := make([]byte, def.Minlen)
+		for j, v := len(b)-1, i; j >= 0; j-- {
+			b[j] = def.Chars[v%len(def.Chars)]
+			v /= len(def.Chars)
+		}
+
+		idents = append(idents, &IdentAlias{
+			Ident: fmt.Sprintf("fill-%d", i),
+			Alias: string(b),
+		})
+	}
+
+	if err := s.Store.Set(def, idents); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerGrowMaxAttempts(t *testing.T) {
+	defer func(n, m int) { MaxAttempts, GrowSamples = n, m }(MaxAttempts, GrowSamples)
+	MaxAttempts = 5
+	GrowSamples = 1 << 20
+
+	s := initServer(t)
+
+	def := NewDef()
+	def.Name = "grow"
+	def.Type = "rand"
+	def.Chars = "abcdefgh"
+	def.Minlen = MinRandMinlen
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	fillRandSpace(t, s, def)
+
+	// Growth disabled.
+	if _, err := s.Gen(def, []*IdentAlias{{Ident: "1"}}); err != ErrMaxAttemptsReached {
+		t.Fatalf("expected max attempts error, got %v", err)
+	}
+
+	s.GrowRate = 0.25
+
+	idents, err := s.Gen(def, []*IdentAlias{{Ident: "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(idents[0].Alias) != MinRandMinlen+1 {
+		t.Errorf("expected alias of length %d, got %s", MinRandMinlen+1, idents[0].Alias)
+	}
+
+	stored, err := s.Store.GetDef(def.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.Minlen != MinRandMinlen+1 {
+		t.Errorf("expected stored min length %d, got %d", MinRandMinlen+1, stored.Minlen)
+	}
+}
+
+func TestServerGrowCollisionRate(t *testing.T) {
+	defer func(n int) { GrowSamples = n }(GrowSamples)
+	GrowSamples = 10
+
+	s := initServer(t)
+	s.GrowRate = 0.25
+
+	def := NewDef()
+	def.Name = "grow"
+	def.Type = "rand"
+	def.Chars = "abcdefgh"
+	def.Minlen = MinRandMinlen
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	fillRandSpace(t, s, def)
+
+	// Every candidate collides, so the length grows well before the max
+	// attempts are reached.
+	idents, err := s.Gen(def, []*IdentAlias{{Ident: "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(idents[0].Alias) != MinRandMinlen+1 {
+		t.Errorf("expected alias of length %d, got %s", MinRandMinlen+1, idents[0].Alias)
+	}
+
+	if v := minlenGrowths.Get(def.Name); v == nil {
+		t.Error("expected growth to be counted")
+	}
+}
+
+func TestServerBlocklist(t *testing.T) {
+	s := initServer(t)
+
+	def := NewDef()
+	def.Name = "blocked"
+	def.Type = "rand"
+	def.Chars = "abcdefgh"
+	def.Minlen = MinRandMinlen
+	def.Prefix = "ab-"
+	def.Block = []string{"a", "B"}
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	idents := make([]*IdentAlias, 50)
+	for i := range idents {
+		idents[i] = &IdentAlias{Ident: strconv.Itoa(i)}
+	}
+
+	if _, err := s.Gen(def, idents); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ia := range idents {
+		if strings.ContainsAny(strings.TrimPrefix(ia.Alias, "ab-"), "ab") {
+			t.Errorf("alias %s contains blocked substring", ia.Alias)
+		}
+	}
+
+	if blockedAliases.Get(def.Name) == nil {
+		t.Error("expected rejections to be counted")
+	}
+
+	def = NewDef()
+	def.Name = "derived"
+	def.Type = "uuidv5"
+	def.Namespace = "dns"
+	def.Profanity = true
+
+	if err := s.CreateDef(def); err == nil {
+		t.Error("expected error for blocklist of derived aliases")
+	}
+
+	def.Type = "rand"
+	def.Block = []string{""}
+
+	if err := s.CreateDef(def); err == nil {
+		t.Error("expected error for empty blocklist entry")
+	}
+}
+
+func TestServerAlphabet(t *testing.T) {
+	s := initServer(t)
+
+	def := NewDef()
+	def.Name = "crockford"
+	def.Type = "rand"
+	def.Alphabet = "crockford"
+	def.Check = "luhn"
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	if def.Chars != crockford {
+		t.Fatalf("expected preset chars, got %s", def.Chars)
+	}
+
+	idents, err := s.Gen(def, []*IdentAlias{{Ident: "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alias := idents[0].Alias
+
+	if strings.Trim(alias, crockford) != "" {
+		t.Fatalf("unexpected alias %s", alias)
+	}
+
+	// Matched ignoring case.
+	valid, err := s.Validate(def, []string{strings.ToLower(alias)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !valid[0] {
+		t.Errorf("expected %s to be valid", strings.ToLower(alias))
+	}
+
+	if err := s.Put(def, []*IdentAlias{{Ident: "2", Alias: "abcdo"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	idents, err = s.Get(def, []*IdentAlias{{Ident: "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if idents[0].Alias != "ABCD0" {
+		t.Errorf("expected normalized alias, got %s", idents[0].Alias)
+	}
+
+	def = NewDef()
+	def.Name = "bad"
+	def.Type = "seq"
+	def.Alphabet = "numeric"
+
+	if err := s.CreateDef(def); err == nil {
+		t.Error("expected error for alphabet of seq")
+	}
+
+	def.Type = "rand"
+	def.Alphabet = "base64"
+
+	if err := s.CreateDef(def); err == nil {
+		t.Error("expected error for unknown alphabet")
+	}
+
+	def.Alphabet = ""
+	def.Chars = "abcdefghh"
+
+	if err := s.CreateDef(def); err == nil {
+		t.Error("expected error for duplicate chars")
+	}
+}
+
+func TestServerLegacyChars(t *testing.T) {
+	s := initServer(t)
+
+	// Default chars of definitions created before chars had to be unique.
+	def := NewDef()
+	def.Name = "legacy"
+	def.Type = "rand"
+	def.Chars = "abcdefghijklmnopqrstuvwzyz0123456789"
+
+	if err := s.Store.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	def.Block = []string{"abc"}
+
+	if err := s.UpdateDef(def.Name, def); err != nil {
+		t.Errorf("expected update to keep the chars, got %v", err)
+	}
+
+	def.Chars = "abcdefghh"
+
+	if err := s.UpdateDef(def.Name, def); err == nil {
+		t.Error("expected error for duplicate chars")
+	}
+
+	def.Chars = RandChars
+
+	if err := s.UpdateDef(def.Name, def); err != nil {
+		t.Errorf("expected chars to be updated, got %v", err)
+	}
+}
+
+func TestServerStats(t *testing.T) {
+	s := initServer(t)
+
+	def := NewDef()
+	def.Name = "stats"
+	def.Type = "rand"
+	def.Chars = "abcdefgh"
+	def.Minlen = MinRandMinlen
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	idents := make([]*IdentAlias, 10)
+	for i := range idents {
+		idents[i] = &IdentAlias{Ident: strconv.Itoa(i)}
+	}
+
+	if _, err := s.Gen(def, idents); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := s.Stats(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Aliases != 10 || st.Space.Int64() != 4096 {
+		t.Errorf("expected 10 of 4096 aliases, got %d of %s", st.Aliases, st.Space)
+	}
+
+	if st.Fill != 10.0/4096 {
+		t.Errorf("unexpected fill %f", st.Fill)
+	}
+
+	var n int64
+	for _, b := range st.Attempts {
+		n += b.Count
+	}
+
+	if n != 10 {
+		t.Errorf("expected 10 aliases in histogram, got %d", n)
+	}
+
+	if last := st.Attempts[len(st.Attempts)-1]; last.Min != 101 || last.Max != 0 {
+		t.Errorf("unexpected last bucket %+v", last)
+	}
+
+	// Sequence range.
+	def = NewDef()
+	def.Name = "seq"
+	def.Type = "seq"
+	def.Width = 3
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	if st, err = s.Stats(def); err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Space.Int64() != 999 || st.Aliases != 0 {
+		t.Errorf("expected 0 of 999 aliases, got %d of %s", st.Aliases, st.Space)
+	}
+}
+
+func TestServerReverse(t *testing.T) {
+	s := initServer(t)
+
+	def := NewDef()
+	def.Name = "reverse"
+	def.Type = "rand"
+	def.Reverse = true
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	idents, err := s.Gen(def, []*IdentAlias{{Ident: "subject-1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alias := idents[0].Alias
+
+	if _, err := s.Reverse(def, []string{alias}, "irb-42", "test"); err != ErrNoAudit {
+		t.Errorf("expected audit error, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	if s.Audit, err = OpenAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Audit.Close()
+
+	if _, err := s.Reverse(def, []string{alias}, " ", "test"); err != ErrNoReason {
+		t.Errorf("expected reason error, got %v", err)
+	}
+
+	idents, err = s.Reverse(def, []string{alias, "missing"}, "irb-42", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if idents[0].Ident != "subject-1" || idents[0].Status != StatusExists || idents[1].Status != StatusMissing {
+		t.Errorf("unexpected idents %+v, %+v", *idents[0], *idents[1])
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rec AuditRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Action != "reverse" || rec.Def != def.Name || rec.Reason != "irb-42" || rec.Found != 1 || len(rec.Aliases) != 2 {
+		t.Errorf("unexpected audit record %+v", rec)
+	}
+
+	if bytes.Contains(b, []byte("subject-1")) {
+		t.Error("expected ident not to be recorded")
+	}
+
+	// Only set on creation.
+	def.Reverse = false
+
+	if err := s.UpdateDef(def.Name, def); err != ErrReverseChanged {
+		t.Errorf("expected reverse change error, got %v", err)
+	}
+
+	plain := NewDef()
+	plain.Name = "plain"
+	plain.Type = "rand"
+
+	if err := s.CreateDef(plain); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Reverse(plain, []string{alias}, "irb-42", "test"); err != ErrNoReverseIndex {
+		t.Errorf("expected reverse index error, got %v", err)
+	}
+}
+
+func TestServerHashIdents(t *testing.T) {
+	s := initServer(t)
+	s.Keys = Keyring{"idents": bytes.Repeat([]byte{2}, 32)}
+	s.IdentKey = "idents"
+
+	def := NewDef()
+	def.Name = "hashed"
+	def.Type = "rand"
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	if def.IdentKey != "idents" {
+		t.Fatalf("expected server ident key, got '%s'", def.IdentKey)
+	}
+
+	idents, err := s.Gen(def, []*IdentAlias{{Ident: "mrn-1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alias := idents[0].Alias
+
+	if idents[0].Ident != "mrn-1" {
+		t.Errorf("expected raw ident in response, got %s", idents[0].Ident)
+	}
+
+	if err := s.Put(def, []*IdentAlias{{Ident: "mrn-2", Alias: "put-2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := s.Store.Idents(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ident := range stored {
+		if !isHashedIdent(ident) {
+			t.Errorf("expected hashed ident, got %s", ident)
+		}
+	}
+
+	idents, err = s.Get(def, []*IdentAlias{{Ident: "mrn-1"}, {Ident: "mrn-2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if idents[0].Alias != alias || idents[1].Alias != "put-2" {
+		t.Errorf("unexpected aliases %s, %s", idents[0].Alias, idents[1].Alias)
+	}
+
+	if err := s.Del(def, []string{"mrn-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := s.Store.Count(def); n != 1 {
+		t.Errorf("expected 1 alias after delete, got %d", n)
+	}
+
+	// Incompatible with a reverse index.
+	def = NewDef()
+	def.Name = "reverse"
+	def.Type = "rand"
+	def.Reverse = true
+
+	if err := s.CreateDef(def); err == nil {
+		t.Error("expected error for reverse index with hashed idents")
+	}
+}
+
+func TestServerMigrateIdents(t *testing.T) {
+	s := initServer(t)
+	s.Keys = Keyring{"idents": bytes.Repeat([]byte{2}, 32)}
+
+	def := NewDef()
+	def.Name = "plain"
+	def.Type = "rand"
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	idents := make([]*IdentAlias, 10)
+	for i := range idents {
+		idents[i] = &IdentAlias{Ident: strconv.Itoa(i)}
+	}
+
+	if _, err := s.Gen(def, idents); err != nil {
+		t.Fatal(err)
+	}
+
+	aliases := make(map[string]string)
+	for _, ia := range idents {
+		aliases[ia.Ident] = ia.Alias
+	}
+
+	// An interrupted migration marked the def and renamed the first ident.
+	def.IdentKey = "idents"
+	def.Migrating = true
+
+	if err := s.Store.UpdateDef(def.Name, def); err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := s.Keys.Get("idents")
+	if err := s.Store.RenameIdents(def, []string{"0"}, []string{hashIdent(key, "0")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get(def, []*IdentAlias{{Ident: "1"}}); err != ErrMigrating {
+		t.Errorf("expected migrating error, got %v", err)
+	}
+
+	n, err := s.MigrateIdents(def, "idents")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 9 {
+		t.Errorf("expected 9 idents migrated, got %d", n)
+	}
+
+	stored, err := s.GetDef(def.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.IdentKey != "idents" || stored.Migrating {
+		t.Fatalf("expected ident key to be stored, got '%s'", stored.IdentKey)
+	}
+
+	lookups := make([]*IdentAlias, 10)
+	for i := range lookups {
+		lookups[i] = &IdentAlias{Ident: strconv.Itoa(i)}
+	}
+
+	if _, err := s.Get(stored, lookups); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ia := range lookups {
+		if ia.Status != StatusExists || ia.Alias != aliases[ia.Ident] {
+			t.Errorf("expected %s to keep alias %s, got %s", ia.Ident, aliases[ia.Ident], ia.Alias)
+		}
+	}
+
+	if _, err := s.MigrateIdents(stored, "idents"); err == nil {
+		t.Error("expected error for migrated def")
+	}
+
+	stored.IdentKey = ""
+
+	if err := s.UpdateDef(stored.Name, stored); err != ErrIdentKeyChanged {
+		t.Errorf("expected ident key change error, got %v", err)
+	}
+}
+
+func TestServerMigrateIdentsHashedForm(t *testing.T) {
+	s := initServer(t)
+	s.Keys = Keyring{"idents": bytes.Repeat([]byte{2}, 32)}
+
+	def := NewDef()
+	def.Name = "plain"
+	def.Type = "rand"
+
+	if err := s.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	// A raw ident of the form would be skipped when resuming.
+	raw := hashIdent([]byte("other"), "1")
+
+	if _, err := s.Gen(def, []*IdentAlias{{Ident: raw}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.MigrateIdents(def, "idents"); err == nil {
+		t.Error("expected error for ident of the hashed form")
+	}
+
+	stored, err := s.GetDef(def.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.IdentKey != "" || stored.Migrating {
+		t.Error("expected the def to be unchanged")
+	}
+}
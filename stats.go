@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math/big"
+)
+
+// AttemptBuckets are the upper bounds of the attempt histogram buckets. Idents
+// that took more attempts, which is possible when the min length grows, are
+// counted in a last unbounded bucket.
+var AttemptBuckets = []int{1, 2, 3, 5, 10, 25, 50, 100}
+
+// AttemptBucket counts the aliases that were claimed after between Min and
+// Max attempts. Max is zero for the unbounded bucket.
+type AttemptBucket struct {
+	Min   int   `json:"min"`
+	Max   int   `json:"max,omitempty"`
+	Count int64 `json:"count"`
+}
+
+// DefStats reports how full the alias space of a definition is.
+type DefStats struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+
+	// Number of aliases.
+	Aliases int `json:"aliases"`
+
+	// Number of distinct aliases the generator can generate, or nil if it is
+	// unbounded or unknown.
+	Space *big.Int `json:"space"`
+
+	// Ratio of aliases to the space, or zero if it is unbounded.
+	Fill float64 `json:"fill"`
+
+	// Histogram of the attempts generated aliases took since the server
+	// started.
+	Attempts []*AttemptBucket `json:"attempts"`
+}
+
+// recordAttempts adds n aliases claimed after the number of attempts to the
+// histogram of the definition.
+func (s *Server) recordAttempts(def *Def, attempts, n int) {
+	if n == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.attempts == nil {
+		s.attempts = make(map[int][]int64)
+	}
+
+	h, ok := s.attempts[def.ID]
+	if !ok {
+		h = make([]int64, len(AttemptBuckets)+1)
+		s.attempts[def.ID] = h
+	}
+
+	i := 0
+	for i < len(AttemptBuckets) && attempts > AttemptBuckets[i] {
+		i++
+	}
+
+	h[i] += int64(n)
+}
+
+// attemptHistogram returns the buckets of the histogram of the definition.
+func (s *Server) attemptHistogram(def *Def) []*AttemptBucket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := s.attempts[def.ID]
+
+	buckets := make([]*AttemptBucket, len(AttemptBuckets)+1)
+	min := 1
+
+	for i := range buckets {
+		b := &AttemptBucket{Min: min}
+
+		if i < len(AttemptBuckets) {
+			b.Max = AttemptBuckets[i]
+			min = b.Max + 1
+		}
+
+		if h != nil {
+			b.Count = h[i]
+		}
+
+		buckets[i] = b
+	}
+
+	return buckets
+}
+
+// Stats returns the number of aliases of the definition, the size of its
+// alias space, and the attempts aliases took to generate.
+func (s *Server) Stats(def *Def) (*DefStats, error) {
+	n, err := s.Store.Count(def)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &DefStats{
+		ID:       def.ID,
+		Name:     def.Name,
+		Aliases:  n,
+		Attempts: s.attemptHistogram(def),
+	}
+
+	gen, err := MakeGen(s.Store, s.Keys, def)
+	if err != nil {
+		return nil, err
+	}
+
+	if sg, ok := gen.(SpaceGen); ok {
+		st.Space = sg.Space()
+	}
+
+	if st.Space != nil && st.Space.Sign() > 0 {
+		st.Fill, _ = new(big.Float).Quo(
+			new(big.Float).SetInt64(int64(n)),
+			new(big.Float).SetInt(st.Space),
+		).Float64()
+	}
+
+	return st, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import "errors"
+
+var (
+	// ErrAliasExists is returned when an alias is explicitly set that is
+	// already assigned to another ident and the store enforces uniqueness.
+	ErrAliasExists = errors.New("alias exists")
+)
+
+// Store persists alias generation definitions and the ident/alias mappings
+// scoped by them.
+type Store interface {
+	// CreateDef stores a new definition and assigns its ID. ErrDefExists is
+	// returned if a definition by the same name exists.
+	CreateDef(def *Def) error
+
+	// GetDef returns the definition by name or ErrNoDef.
+	GetDef(name string) (*Def, error)
+
+	// GetDefByID returns the definition by ID, including archived ones, or
+	// ErrNoDef.
+	GetDefByID(id int) (*Def, error)
+
+	// GetDefs returns the page of definitions in the state of the query
+	// ordered by ID, and the number of definitions in the state.
+	GetDefs(q DefsQuery) ([]*Def, int, error)
+
+	// UpdateDef stores the definition under its current name, removing the
+	// previous name if it changed.
+	UpdateDef(name string, def *Def) error
+
+	// DelDef removes the name entry of the definition, making it inaccessible,
+	// and stores its updated value. The aliases are left in place.
+	DelDef(def *Def) error
+
+	// RestoreDef recreates the name entry of an archived definition and stores
+	// its updated value. ErrDefExists is returned if another definition has
+	// taken the name.
+	RestoreDef(def *Def) error
+
+	// Lookup sets the alias and status of each ident, either StatusExists or
+	// StatusMissing.
+	Lookup(def *Def, idents []*IdentAlias) error
+
+	// Claim assigns the candidate alias of each ident in order. The status is
+	// set to StatusCreated, or StatusExists along with the current alias if the
+	// ident already has one. If the alias is taken by another ident, the alias
+	// and status are cleared so a new candidate can be generated.
+	Claim(def *Def, idents []*IdentAlias) error
+
+	// Set explicitly assigns the aliases to the idents, replacing their
+	// current aliases. The memory, Redis, and Bolt stores also overwrite the
+	// entry of an alias assigned to another ident. The Postgres store enforces
+	// unique aliases and returns ErrAliasExists without setting any of the
+	// idents passed to the call instead.
+	Set(def *Def, idents []*IdentAlias) error
+
+	// Reverse sets the ident and status of each alias, either StatusExists or
+	// StatusMissing. Only aliases of definitions with a reverse index can be
+	// found.
+	Reverse(def *Def, aliases []*IdentAlias) error
+
+	// Del removes the idents and their aliases and returns the number of
+	// idents that had an alias.
+	Del(def *Def, idents []string) (int, error)
+
+	// Idents returns all idents of the definition.
+	Idents(def *Def) ([]string, error)
+
+	// RenameIdents replaces each ident in from with the ident at the same
+	// index in to, keeping its alias. Idents that do not exist are skipped.
+	// An error is returned if a new ident already exists.
+	RenameIdents(def *Def, from, to []string) error
+
+	// Count returns the number of aliases of the definition.
+	Count(def *Def) (int, error)
+
+	// NextSeq increments the sequence of the definition by n and returns the
+	// last value.
+	NextSeq(def *Def, n int64) (int64, error)
+
+	// Purge removes up to n idents and aliases of an archived definition and
+	// returns the number of idents removed. Once none are left, the sequence
+	// and the definition itself are removed and done is true.
+	Purge(def *Def, n int) (removed int, done bool, err error)
+
+	// Close releases the resources held by the store.
+	Close() error
+}
+
+// pageDefs returns the page of the definitions ordered by ID in the state of
+// the query, and the number of definitions in the state.
+func pageDefs(all []*Def, q DefsQuery) ([]*Def, int) {
+	defs := all[:0]
+
+	for _, def := range all {
+		switch q.State {
+		case DefsActive:
+			if def.Deleted {
+				continue
+			}
+		case DefsArchived:
+			if !def.Deleted {
+				continue
+			}
+		}
+
+		defs = append(defs, def)
+	}
+
+	total := len(defs)
+
+	if q.Offset > total {
+		q.Offset = total
+	}
+
+	defs = defs[q.Offset:]
+
+	if q.Limit > 0 && q.Limit < len(defs) {
+		defs = defs[:q.Limit]
+	}
+
+	return defs, total
+}
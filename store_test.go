@@ -0,0 +1,981 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	bolt "go.etcd.io/bbolt"
+)
+
+func lookup(t *testing.T, st Store, def *Def, ident string) (string, bool) {
+	ia := &IdentAlias{Ident: ident}
+	if err := st.Lookup(def, []*IdentAlias{ia}); err != nil {
+		t.Fatal(err)
+	}
+	return ia.Alias, ia.Status == StatusExists
+}
+
+func claim(t *testing.T, st Store, def *Def, ident, alias string) (string, Status) {
+	ia := &IdentAlias{Ident: ident, Alias: alias}
+	if err := st.Claim(def, []*IdentAlias{ia}); err != nil {
+		t.Error(err)
+	}
+	return ia.Alias, ia.Status
+}
+
+// testStore checks the def and alias semantics common to all stores.
+func testStore(t *testing.T, st Store) {
+	def := NewDef()
+	def.Name = "store"
+	def.Type = "rand"
+
+	if err := st.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	if def.ID == 0 {
+		t.Fatal("expected id to be assigned")
+	}
+
+	if err := st.CreateDef(&Def{Name: "store", Type: "rand"}); err != ErrDefExists {
+		t.Fatalf("expected ErrDefExists, got %v", err)
+	}
+
+	got, err := st.GetDef("store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, def) {
+		t.Errorf("expected %+v, got %+v", def, got)
+	}
+
+	if _, err := st.GetDef("missing"); err != ErrNoDef {
+		t.Errorf("expected ErrNoDef, got %v", err)
+	}
+
+	// Idents and aliases.
+	if _, ok := lookup(t, st, def, "a"); ok {
+		t.Fatal("expected no alias")
+	}
+
+	alias, status := claim(t, st, def, "a", "x1")
+	if alias != "x1" || status != StatusCreated {
+		t.Errorf("expected created x1, got %s %s", status, alias)
+	}
+
+	alias, status = claim(t, st, def, "a", "x2")
+	if alias != "x1" || status != StatusExists {
+		t.Errorf("expected existing x1, got %s %s", status, alias)
+	}
+
+	if alias, status := claim(t, st, def, "b", "x1"); alias != "" || status != 0 {
+		t.Errorf("expected x1 to be taken, got %s %s", status, alias)
+	}
+
+	if err := st.Set(def, []*IdentAlias{{Ident: "b", Alias: "x2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if alias, ok := lookup(t, st, def, "b"); !ok || alias != "x2" {
+		t.Errorf("expected x2, got %s", alias)
+	}
+
+	// Batches are applied in order.
+	batch := []*IdentAlias{
+		{Ident: "d", Alias: "x3"},
+		{Ident: "d", Alias: "x4"},
+		{Ident: "e", Alias: "x3"},
+		{Ident: "f", Alias: "x5"},
+	}
+
+	if err := st.Claim(def, batch); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, exp := range []IdentAlias{
+		{Ident: "d", Alias: "x3", Status: StatusCreated},
+		{Ident: "d", Alias: "x3", Status: StatusExists},
+		{Ident: "e"},
+		{Ident: "f", Alias: "x5", Status: StatusCreated},
+	} {
+		if *batch[i] != exp {
+			t.Errorf("expected %+v, got %+v", exp, *batch[i])
+		}
+	}
+
+	lookups := []*IdentAlias{{Ident: "f"}, {Ident: "e"}, {Ident: "d"}}
+	if err := st.Lookup(def, lookups); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, exp := range []IdentAlias{
+		{Ident: "f", Alias: "x5", Status: StatusExists},
+		{Ident: "e", Status: StatusMissing},
+		{Ident: "d", Alias: "x3", Status: StatusExists},
+	} {
+		if *lookups[i] != exp {
+			t.Errorf("expected %+v, got %+v", exp, *lookups[i])
+		}
+	}
+
+	if n, err := st.Del(def, []string{"d", "e", "f"}); err != nil || n != 2 {
+		t.Errorf("expected 2 deleted, got %d (%v)", n, err)
+	}
+
+	// Aliases are scoped by the definition.
+	other := NewDef()
+	other.Name = "other"
+	other.Type = "rand"
+
+	if err := st.CreateDef(other); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := lookup(t, st, other, "a"); ok {
+		t.Error("expected alias to be scoped by def")
+	}
+
+	if _, status := claim(t, st, other, "c", "x1"); status != StatusCreated {
+		t.Error("expected alias to be free in other def")
+	}
+
+	if n, err := st.Count(other); err != nil || n != 1 {
+		t.Errorf("expected 1 alias in other def, got %d (%v)", n, err)
+	}
+
+	n, err := st.Del(def, []string{"a"})
+	if err != nil || n != 1 {
+		t.Fatalf("expected delete, got %d (%v)", n, err)
+	}
+
+	if n, _ := st.Del(def, []string{"a"}); n != 0 {
+		t.Error("expected second delete to be skipped")
+	}
+
+	// Alias is free again.
+	if _, status := claim(t, st, def, "c", "x1"); status != StatusCreated {
+		t.Error("expected alias to be freed")
+	}
+
+	// Rename.
+	def.Name = "renamed"
+	if err := st.UpdateDef("store", def); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := st.GetDef("store"); err != ErrNoDef {
+		t.Errorf("expected old name to be removed, got %v", err)
+	}
+
+	if _, err := st.GetDef("renamed"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Archive.
+	def.Deleted = true
+	if err := st.DelDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := st.GetDef("renamed"); err != ErrNoDef {
+		t.Errorf("expected archived def to be inaccessible, got %v", err)
+	}
+
+	defs, _, err := st.GetDefs(DefsQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(defs) != 2 {
+		t.Fatalf("expected 2 defs, got %d", len(defs))
+	}
+
+	var archived int
+	for _, d := range defs {
+		if d.Deleted {
+			archived++
+		}
+	}
+
+	if archived != 1 {
+		t.Errorf("expected 1 archived def, got %d", archived)
+	}
+
+	// Pages of the definitions in a state.
+	for _, test := range []struct {
+		q     DefsQuery
+		names string
+		total int
+	}{
+		{DefsQuery{State: DefsActive}, "[other]", 1},
+		{DefsQuery{State: DefsArchived}, "[renamed]", 1},
+		{DefsQuery{Offset: 1}, "[other]", 2},
+		{DefsQuery{Limit: 1}, "[renamed]", 2},
+		{DefsQuery{Offset: 5}, "[]", 2},
+	} {
+		defs, total, err := st.GetDefs(test.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, d := range defs {
+			names = append(names, d.Name)
+		}
+
+		if fmt.Sprint(names) != test.names || total != test.total {
+			t.Errorf("%+v: expected %s of %d, got %v of %d", test.q, test.names, test.total, names, total)
+		}
+	}
+
+	// Archived aliases are kept.
+	if alias, ok := lookup(t, st, def, "b"); !ok || alias != "x2" {
+		t.Errorf("expected archived alias to be kept, got %s", alias)
+	}
+
+	if got, err := st.GetDefByID(def.ID); err != nil || !got.Deleted {
+		t.Errorf("expected archived def by id, got %+v (%v)", got, err)
+	}
+
+	if _, err := st.GetDefByID(1000); err != ErrNoDef {
+		t.Errorf("expected ErrNoDef, got %v", err)
+	}
+
+	// Restore.
+	def.Deleted = false
+	if err := st.RestoreDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := st.GetDef("renamed"); err != nil || got.ID != def.ID || got.Deleted {
+		t.Errorf("expected restored def, got %+v (%v)", got, err)
+	}
+
+	def.Deleted = true
+	if err := st.DelDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	// The name can be reused.
+	if err := st.CreateDef(&Def{Name: "renamed", Type: "uuid"}); err != nil {
+		t.Errorf("expected name to be reusable, got %v", err)
+	}
+
+	// It cannot be restored once the name is taken.
+	def.Deleted = false
+	if err := st.RestoreDef(def); err != ErrDefExists {
+		t.Errorf("expected ErrDefExists, got %v", err)
+	}
+}
+
+// testStoreSeq checks the sequence is incremented atomically.
+func testStoreSeq(t *testing.T, st Store) {
+	def := NewDef()
+	def.Name = "seq"
+	def.Type = "seq"
+
+	if err := st.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		seen = make(map[int64]bool)
+	)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 25; j++ {
+				n, err := st.NextSeq(def, 1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				mu.Lock()
+				if seen[n] {
+					t.Errorf("duplicate seq %d", n)
+				}
+				seen[n] = true
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(seen) != 200 {
+		t.Errorf("expected 200 values, got %d", len(seen))
+	}
+
+	last, err := st.NextSeq(def, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if last-int64(len(seen)) != def.seqInit()+10 {
+		t.Errorf("expected increment by 10, got %d", last)
+	}
+}
+
+// testStoreClaim checks concurrent claims never assign two aliases to an
+// ident or one alias to two idents.
+func testStoreClaim(t *testing.T, st Store) {
+	def := NewDef()
+	def.Name = "claim"
+	def.Type = "rand"
+
+	if err := st.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created = make(map[string]string)
+	)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 25; j++ {
+				// Every worker races for the same idents with its own aliases,
+				// and for the same aliases with its own idents.
+				for _, p := range [][2]string{
+					{fmt.Sprintf("ident-%d", j), fmt.Sprintf("alias-%d-%d", i, j)},
+					{fmt.Sprintf("ident-%d-%d", i, j), fmt.Sprintf("shared-%d", j)},
+				} {
+					alias, status := claim(t, st, def, p[0], p[1])
+					if status != StatusCreated {
+						continue
+					}
+
+					mu.Lock()
+					if ident, ok := created[alias]; ok {
+						t.Errorf("alias %s created for %s and %s", alias, ident, p[0])
+					}
+					created[alias] = p[0]
+					mu.Unlock()
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	idents := make(map[string]bool)
+	for _, ident := range created {
+		if idents[ident] {
+			t.Errorf("ident %s created twice", ident)
+		}
+		idents[ident] = true
+	}
+
+	// One winner per ident and per shared alias.
+	if len(created) != 50 {
+		t.Errorf("expected 50 created, got %d", len(created))
+	}
+}
+
+// testStoreReverse checks the idents of aliases can only be looked up if the
+// definition has a reverse index.
+func testStoreReverse(t *testing.T, st Store) {
+	def := NewDef()
+	def.Name = "reverse"
+	def.Type = "rand"
+	def.Reverse = true
+
+	plain := NewDef()
+	plain.Name = "plain"
+	plain.Type = "rand"
+
+	for _, d := range []*Def{def, plain} {
+		if err := st.CreateDef(d); err != nil {
+			t.Fatal(err)
+		}
+
+		claim(t, st, d, "a", "x1")
+
+		if err := st.Set(d, []*IdentAlias{{Ident: "b", Alias: "x2"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	aliases := []*IdentAlias{{Alias: "x2"}, {Alias: "x3"}, {Alias: "x1"}}
+	if err := st.Reverse(def, aliases); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, exp := range []IdentAlias{
+		{Ident: "b", Alias: "x2", Status: StatusExists},
+		{Alias: "x3", Status: StatusMissing},
+		{Ident: "a", Alias: "x1", Status: StatusExists},
+	} {
+		if *aliases[i] != exp {
+			t.Errorf("expected %+v, got %+v", exp, *aliases[i])
+		}
+	}
+
+	aliases = []*IdentAlias{{Alias: "x1"}}
+	if err := st.Reverse(plain, aliases); err != nil {
+		t.Fatal(err)
+	}
+
+	if aliases[0].Status != StatusMissing || aliases[0].Ident != "" {
+		t.Errorf("expected no reverse index, got %+v", *aliases[0])
+	}
+
+	if _, err := st.Del(def, []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	aliases = []*IdentAlias{{Alias: "x1"}}
+	if err := st.Reverse(def, aliases); err != nil {
+		t.Fatal(err)
+	}
+
+	if aliases[0].Status != StatusMissing {
+		t.Errorf("expected deleted alias to be missing, got %+v", *aliases[0])
+	}
+}
+
+// testStoreRenameIdents checks idents can be replaced keeping their aliases.
+func testStoreRenameIdents(t *testing.T, st Store) {
+	def := NewDef()
+	def.Name = "rename"
+	def.Type = "rand"
+
+	if err := st.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	claim(t, st, def, "a", "x1")
+	claim(t, st, def, "b", "x2")
+	claim(t, st, def, "c", "x3")
+
+	idents, err := st.Idents(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(idents)
+
+	if !reflect.DeepEqual(idents, []string{"a", "b", "c"}) {
+		t.Errorf("unexpected idents %v", idents)
+	}
+
+	// Missing idents are skipped.
+	if err := st.RenameIdents(def, []string{"a", "z"}, []string{"A", "Z"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if alias, ok := lookup(t, st, def, "A"); !ok || alias != "x1" {
+		t.Errorf("expected renamed ident to keep alias, got %s", alias)
+	}
+
+	if _, ok := lookup(t, st, def, "a"); ok {
+		t.Error("expected old ident to be removed")
+	}
+
+	// The alias is still taken.
+	if _, status := claim(t, st, def, "d", "x1"); status != 0 {
+		t.Error("expected alias to stay taken")
+	}
+
+	if err := st.RenameIdents(def, []string{"b"}, []string{"c"}); err == nil {
+		t.Error("expected error for existing ident")
+	}
+}
+
+// testStorePurge checks an archived definition is removed incrementally
+// without affecting other definitions.
+func testStorePurge(t *testing.T, st Store) {
+	def := NewDef()
+	def.Name = "purge"
+	def.Type = "seq"
+
+	other := NewDef()
+	other.Name = "kept"
+	other.Type = "rand"
+
+	for _, d := range []*Def{def, other} {
+		if err := st.CreateDef(d); err != nil {
+			t.Fatal(err)
+		}
+
+		var batch []*IdentAlias
+		for i := 0; i < 25; i++ {
+			batch = append(batch, &IdentAlias{Ident: fmt.Sprint(i), Alias: fmt.Sprint("x", i)})
+		}
+
+		if err := st.Claim(d, batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := st.NextSeq(def, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	def.Deleted = true
+	if err := st.DelDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		removed int
+		steps   int
+	)
+
+	for {
+		n, done, err := st.Purge(def, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		removed += n
+		steps++
+
+		if done {
+			break
+		}
+
+		if steps > 25 {
+			t.Fatal("purge did not finish")
+		}
+	}
+
+	if removed != 25 {
+		t.Errorf("expected 25 removed, got %d", removed)
+	}
+
+	if steps < 2 {
+		t.Errorf("expected purge in batches, got %d steps", steps)
+	}
+
+	if _, ok := lookup(t, st, def, "1"); ok {
+		t.Error("expected ident to be purged")
+	}
+
+	if alias, ok := lookup(t, st, other, "1"); !ok || alias != "x1" {
+		t.Error("expected other def to be kept")
+	}
+
+	defs, _, err := st.GetDefs(DefsQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var kept bool
+
+	for _, d := range defs {
+		if d.ID == def.ID {
+			t.Error("expected def to be purged")
+		}
+
+		if d.ID == other.ID {
+			kept = true
+		}
+	}
+
+	if !kept {
+		t.Error("expected other def to be listed")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+	testStoreSeq(t, NewMemoryStore())
+	testStoreClaim(t, NewMemoryStore())
+	testStoreReverse(t, NewMemoryStore())
+	testStoreRenameIdents(t, NewMemoryStore())
+	testStorePurge(t, NewMemoryStore())
+}
+
+func TestRedisStore(t *testing.T) {
+	s := initRedisServer(t)
+	defer s.Close()
+
+	testStore(t, s.Store)
+	testStoreSeq(t, s.Store)
+	testStoreClaim(t, s.Store)
+	testStoreReverse(t, s.Store)
+	testStoreRenameIdents(t, s.Store)
+	testStorePurge(t, s.Store)
+}
+
+func TestBoltStore(t *testing.T) {
+	for _, fn := range []func(*testing.T, Store){testStore, testStoreSeq, testStoreClaim, testStoreReverse, testStoreRenameIdents, testStorePurge} {
+		st, err := NewBoltStore(filepath.Join(t.TempDir(), "aliases.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fn(t, st)
+
+		if err := st.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBoltStoreCount(t *testing.T) {
+	st, err := NewBoltStore(filepath.Join(t.TempDir(), "aliases.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer st.Close()
+
+	def := NewDef()
+	def.Name = "count"
+	def.Type = "rand"
+
+	if err := st.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	claim(t, st, def, "a", "x1")
+	claim(t, st, def, "b", "x2")
+
+	// Setting an existing alias again does not count it twice.
+	for i := 0; i < 2; i++ {
+		if err := st.Set(def, []*IdentAlias{{Ident: "c", Alias: "x3"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := st.Del(def, []string{"a", "z"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := st.Count(def); err != nil || n != 2 {
+		t.Errorf("expected 2 aliases, got %d (%v)", n, err)
+	}
+
+	// Definitions created before the count was kept.
+	err = st.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(countBucket).Delete(itob(int64(def.ID)))
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claim(t, st, def, "d", "x4")
+
+	if n, err := st.Count(def); err != nil || n != 3 {
+		t.Errorf("expected 3 aliases after counting, got %d (%v)", n, err)
+	}
+
+	claim(t, st, def, "e", "x5")
+
+	if n, err := st.Count(def); err != nil || n != 4 {
+		t.Errorf("expected 4 aliases, got %d (%v)", n, err)
+	}
+}
+
+// TestPostgresStore runs against the database set in POSTGRES_URL. The store
+// tables are dropped, so the test is skipped unless it is set explicitly.
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("POSTGRES_URL not set")
+	}
+
+	for _, fn := range []func(*testing.T, Store){testStore, testStoreSeq, testStoreClaim, testStoreReverse, testStoreRenameIdents, testStorePurge} {
+		st, err := NewPostgresStore(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := st.DB.Exec(`truncate alias_keys, alias_defs restart identity`); err != nil {
+			t.Fatal(err)
+		}
+
+		fn(t, st)
+
+		if err := st.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRedisStoreIndexDefs(t *testing.T) {
+	s := initRedisServer(t)
+	defer s.Close()
+
+	st := s.Store.(*RedisStore)
+
+	c := st.Pool.Get()
+	defer c.Close()
+
+	// Definitions created before the registry and an unrelated key.
+	for _, args := range [][]interface{}{
+		{"v:1", `{"id":1,"name":"a","type":"uuid"}`},
+		{"v:2", `{"id":2,"name":"b","type":"uuid","archived":true}`},
+		{"v:foo", "bar"},
+	} {
+		if _, err := c.Do("SET", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defs, _, err := st.GetDefs(DefsQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(defs) != 2 || defs[0].Name != "a" || defs[1].Name != "b" {
+		t.Errorf("expected defs a and b, got %v", defs)
+	}
+}
+
+func TestRedisStoreIndexDefStates(t *testing.T) {
+	s := initRedisServer(t)
+	defer s.Close()
+
+	st := s.Store.(*RedisStore)
+
+	c := st.Pool.Get()
+	defer c.Close()
+
+	// A registry indexed before it was split by state.
+	for _, args := range [][]interface{}{
+		{"v:1", `{"id":1,"name":"a","type":"uuid"}`},
+		{"v:2", `{"id":2,"name":"b","type":"uuid","archived":true}`},
+		{"_:defs:indexed", 1},
+	} {
+		if _, err := c.Do("SET", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := c.Do("ZADD", "_:defs", 1, 1, 2, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	defs, total, err := st.GetDefs(DefsQuery{State: DefsArchived})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != 1 || len(defs) != 1 || defs[0].Name != "b" {
+		t.Errorf("expected def b, got %v", defs)
+	}
+
+	if v, _ := redis.Int(c.Do("GET", "_:defs:indexed")); v != defsIndexVersion {
+		t.Errorf("expected version %d, got %d", defsIndexVersion, v)
+	}
+}
+
+func TestRedisStoreIndexDefsAfterWrite(t *testing.T) {
+	s := initRedisServer(t)
+	defer s.Close()
+
+	st := s.Store.(*RedisStore)
+
+	c := st.Pool.Get()
+	defer c.Close()
+
+	// A definition created before the registry.
+	for _, args := range [][]interface{}{
+		{"v:1", `{"id":1,"name":"a","type":"uuid"}`},
+		{"d:a", 1},
+		{"_:def:id", 1},
+	} {
+		if _, err := c.Do("SET", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Written to the registry before the first listing.
+	def := NewDef()
+	def.Name = "b"
+	def.Type = "uuid"
+
+	if err := st.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	defs, _, err := st.GetDefs(DefsQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(defs) != 2 || defs[0].Name != "a" || defs[1].Name != "b" {
+		t.Errorf("expected defs a and b, got %v", defs)
+	}
+}
+
+func TestRedisStoreLegacySeq(t *testing.T) {
+	s := initRedisServer(t)
+	defer s.Close()
+
+	st := s.Store.(*RedisStore)
+
+	def := NewDef()
+	def.Name = "legacy"
+	def.Type = "seq"
+
+	if err := st.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	c := st.Pool.Get()
+	defer c.Close()
+
+	// Sequence incremented under the legacy key.
+	if _, err := c.Do("SET", "s:%dlegacy", 7); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := st.NextSeq(def, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 8 {
+		t.Errorf("expected legacy sequence to continue at 8, got %d", n)
+	}
+
+	if exists, _ := c.Do("EXISTS", "s:%dlegacy"); exists.(int64) != 0 {
+		t.Error("expected legacy key to be removed")
+	}
+
+	if n, _ := st.NextSeq(def, 1); n != 9 {
+		t.Errorf("expected 9, got %d", n)
+	}
+}
+
+func TestRedisStoreLegacySeqRecreated(t *testing.T) {
+	s := initRedisServer(t)
+	defer s.Close()
+
+	st := s.Store.(*RedisStore)
+
+	old := NewDef()
+	old.Name = "legacy"
+	old.Type = "seq"
+
+	if err := st.CreateDef(old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := st.DelDef(old); err != nil {
+		t.Fatal(err)
+	}
+
+	def := NewDef()
+	def.Name = "legacy"
+	def.Type = "seq"
+
+	if err := st.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	c := st.Pool.Get()
+	defer c.Close()
+
+	// Sequence incremented under the legacy key shared by both definitions.
+	if _, err := c.Do("SET", "s:%dlegacy", 7); err != nil {
+		t.Fatal(err)
+	}
+
+	// The archived definition neither merges nor purges it.
+	if _, err := st.NextSeq(old, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for done := false; !done; {
+		var err error
+		if _, done, err = st.Purge(old, 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, _ := st.NextSeq(def, 1); n != 8 {
+		t.Errorf("expected legacy sequence to continue at 8, got %d", n)
+	}
+}
+
+func TestRedisStoreCount(t *testing.T) {
+	s := initRedisServer(t)
+	defer s.Close()
+
+	st := s.Store.(*RedisStore)
+
+	def := NewDef()
+	def.Name = "count"
+	def.Type = "rand"
+
+	if err := st.CreateDef(def); err != nil {
+		t.Fatal(err)
+	}
+
+	claim(t, st, def, "a", "x1")
+	claim(t, st, def, "b", "x2")
+
+	// Setting an existing alias again does not count it twice.
+	for i := 0; i < 2; i++ {
+		if err := st.Set(def, []*IdentAlias{{Ident: "c", Alias: "x3"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := st.Del(def, []string{"a", "z"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := st.Count(def); err != nil || n != 2 {
+		t.Errorf("expected 2 aliases, got %d (%v)", n, err)
+	}
+
+	c := st.Pool.Get()
+	defer c.Close()
+
+	// Definitions created before the count was kept.
+	if _, err := c.Do("DEL", mk(countPrefix, def.ID)); err != nil {
+		t.Fatal(err)
+	}
+
+	claim(t, st, def, "d", "x4")
+
+	if n, err := st.Count(def); err != nil || n != 3 {
+		t.Errorf("expected 3 aliases after the scan, got %d (%v)", n, err)
+	}
+
+	claim(t, st, def, "e", "x5")
+
+	if n, err := st.Count(def); err != nil || n != 4 {
+		t.Errorf("expected 4 aliases, got %d (%v)", n, err)
+	}
+}
+
+func TestPostgresInsertOrder(t *testing.T) {
+	idents := []*IdentAlias{
+		{Ident: "c", Alias: "x1"},
+		{Ident: "a", Alias: "x3"},
+		{Ident: "b", Alias: "x2"},
+		{Ident: "a", Alias: "x0"},
+	}
+
+	if order := fmt.Sprint(insertOrder(idents)); order != "[3 1 2 0]" {
+		t.Errorf("unexpected order %s", order)
+	}
+}
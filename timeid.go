@@ -0,0 +1,150 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Crockford's base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Process-wide sources so aliases are ordered across requests.
+var (
+	ulidSource   = newMonoSource(80)
+	uuidv7Source = newMonoSource(74)
+)
+
+// monoSource produces a millisecond timestamp and a random value of up to
+// 80 bits that are strictly increasing. Within the same millisecond the
+// previous random value is incremented. If it overflows, or the clock moves
+// backwards, the timestamp of the previous value is reused or advanced.
+type monoSource struct {
+	Now  func() time.Time
+	Rand io.Reader
+
+	mu   sync.Mutex
+	bits uint
+	ms   uint64
+	hi   uint64
+	lo   uint64
+}
+
+func newMonoSource(bits uint) *monoSource {
+	return &monoSource{
+		Now:  time.Now,
+		Rand: crand.Reader,
+		bits: bits,
+	}
+}
+
+// next returns the timestamp and the random value split into the high bits
+// above the lower 64.
+func (s *monoSource) next() (ms, hi, lo uint64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := uint64(s.Now().UnixNano() / int64(time.Millisecond))
+
+	if now > s.ms {
+		var b [16]byte
+		if _, err := io.ReadFull(s.Rand, b[:]); err != nil {
+			return 0, 0, 0, err
+		}
+
+		s.ms = now
+		s.hi = binary.BigEndian.Uint64(b[:8]) & (1<<(s.bits-64) - 1)
+		s.lo = binary.BigEndian.Uint64(b[8:])
+
+		return s.ms, s.hi, s.lo, nil
+	}
+
+	s.lo++
+	if s.lo == 0 {
+		s.hi++
+	}
+
+	if s.hi == 1<<(s.bits-64) {
+		s.ms++
+		s.hi = 0
+	}
+
+	return s.ms, s.hi, s.lo, nil
+}
+
+// ULIDGen generates ULIDs, 26 chars of Crockford's base32 encoding a 48-bit
+// millisecond timestamp and 80 random bits, which sort in generation order.
+type ULIDGen struct {
+	Prefix string
+
+	source *monoSource
+}
+
+// New generates a new ULID.
+func (g *ULIDGen) New() (string, error) {
+	ms, hi, lo, err := g.source.next()
+	if err != nil {
+		return "", err
+	}
+
+	var b [16]byte
+	binary.BigEndian.PutUint16(b[:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:6], uint32(ms))
+	binary.BigEndian.PutUint16(b[6:8], uint16(hi))
+	binary.BigEndian.PutUint64(b[8:], lo)
+
+	return g.Prefix + encodeULID(b), nil
+}
+
+// encodeULID encodes the 128 bits in 5-bit groups, the first char holding the
+// top 3 bits.
+func encodeULID(b [16]byte) string {
+	var (
+		out [26]byte
+		hi  = binary.BigEndian.Uint64(b[:8])
+		lo  = binary.BigEndian.Uint64(b[8:])
+	)
+
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+
+		// Shift the 128-bit value right by 5.
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+
+	return string(out[:])
+}
+
+// UUIDv7Gen generates version 7 UUIDs, which begin with a 48-bit millisecond
+// timestamp followed by 74 random bits and sort in generation order.
+type UUIDv7Gen struct {
+	Prefix string
+
+	source *monoSource
+}
+
+// New generates a new version 7 UUID.
+func (g *UUIDv7Gen) New() (string, error) {
+	ms, hi, lo, err := g.source.next()
+	if err != nil {
+		return "", err
+	}
+
+	// The 74 random bits are split into the 12-bit rand_a and the 62-bit
+	// rand_b around the version and variant.
+	randA := hi<<2 | lo>>62
+	randB := lo & (1<<62 - 1)
+
+	var u uuid.UUID
+	binary.BigEndian.PutUint16(u[:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(u[2:6], uint32(ms))
+	binary.BigEndian.PutUint16(u[6:8], 0x7000|uint16(randA))
+	binary.BigEndian.PutUint64(u[8:], 0x8000000000000000|randB)
+
+	return g.Prefix + u.String(), nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMonoSource(bits uint, now *time.Time) *monoSource {
+	s := newMonoSource(bits)
+	s.Now = func() time.Time { return *now }
+	s.Rand = bytes.NewReader(bytes.Repeat([]byte{0xff, 0x00, 0x7f}, 1000))
+	return s
+}
+
+func TestEncodeULID(t *testing.T) {
+	var b [16]byte
+
+	if s := encodeULID(b); s != "00000000000000000000000000" {
+		t.Errorf("unexpected %s", s)
+	}
+
+	for i := range b {
+		b[i] = 0xff
+	}
+
+	if s := encodeULID(b); s != "7ZZZZZZZZZZZZZZZZZZZZZZZZZ" {
+		t.Errorf("unexpected %s", s)
+	}
+
+	// Timestamp from the ULID spec example.
+	b = [16]byte{0x01, 0x56, 0x3d, 0xf3, 0x64, 0x81}
+
+	if s := encodeULID(b); !strings.HasPrefix(s, "01ARYZ6S41") {
+		t.Errorf("unexpected %s", s)
+	}
+}
+
+func testTimeGen(t *testing.T, g Gen, now *time.Time) {
+	var aliases []string
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 100; j++ {
+			alias, err := g.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			aliases = append(aliases, alias)
+		}
+
+		// Clock moves backwards, then forwards.
+		if i == 0 {
+			*now = now.Add(-time.Second)
+		} else {
+			*now = now.Add(time.Hour)
+		}
+	}
+
+	if !sort.StringsAreSorted(aliases) {
+		t.Error("expected aliases to be sorted")
+	}
+
+	for i := 1; i < len(aliases); i++ {
+		if aliases[i] == aliases[i-1] {
+			t.Fatalf("duplicate alias %s", aliases[i])
+		}
+	}
+}
+
+func TestULIDGen(t *testing.T) {
+	now := time.Unix(1469918176, 385000000)
+
+	g := &ULIDGen{
+		Prefix: "U-",
+		source: testMonoSource(80, &now),
+	}
+
+	alias, err := g.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(alias) != 28 || !strings.HasPrefix(alias, "U-01ARYZ6S41") {
+		t.Errorf("unexpected alias %s", alias)
+	}
+
+	testTimeGen(t, g, &now)
+}
+
+func TestUUIDv7Gen(t *testing.T) {
+	now := time.Unix(1645557742, 0)
+
+	g := &UUIDv7Gen{
+		source: testMonoSource(74, &now),
+	}
+
+	alias, err := g.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Timestamp from the RFC 9562 example.
+	if len(alias) != 36 || !strings.HasPrefix(alias, "017f22e2-79b0-7") {
+		t.Errorf("unexpected alias %s", alias)
+	}
+
+	if !strings.ContainsAny(alias[19:20], "89ab") {
+		t.Errorf("unexpected variant in %s", alias)
+	}
+
+	testTimeGen(t, g, &now)
+}
+
+func TestMonoSourceOverflow(t *testing.T) {
+	now := time.Unix(1, 0)
+
+	s := testMonoSource(74, &now)
+	s.Rand = bytes.NewReader(bytes.Repeat([]byte{0xff}, 16))
+
+	ms, _, _, err := s.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, hi, lo, err := s.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next != ms+1 || hi != 0 || lo != 0 {
+		t.Errorf("expected timestamp to advance, got %d %d %d", next, hi, lo)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+var (
+	// DefaultWords is the default number of words of words aliases.
+	DefaultWords = 3
+	// DefaultWordsSeparator is the default separator between words.
+	DefaultWordsSeparator = "-"
+
+	// MaxWords is the maximum number of words of words aliases.
+	MaxWords = 8
+	// MaxWordsDigits is the maximum number of digits of the numeric suffix.
+	MaxWordsDigits = 6
+
+	// MinWordsEntropy is the minimum entropy in bits allowed for words
+	// generators.
+	MinWordsEntropy = 20.0
+)
+
+// WordsGen generates aliases of random words from the word list joined by
+// the separator, optionally followed by a random numeric suffix, e.g.
+// amber-tiger-42.
+type WordsGen struct {
+	Prefix    string
+	Words     int
+	Separator string
+	Digits    int
+	Source    Source
+}
+
+func newWordsGen(d *Def) *WordsGen {
+	g := &WordsGen{
+		Prefix:    d.Prefix,
+		Words:     d.Words,
+		Separator: d.Separator,
+		Digits:    d.Digits,
+	}
+
+	if g.Words == 0 {
+		g.Words = DefaultWords
+	}
+
+	if g.Separator == "" {
+		g.Separator = DefaultWordsSeparator
+	}
+
+	return g
+}
+
+// Entropy returns the number of random bits of an alias.
+func (g *WordsGen) Entropy() float64 {
+	return float64(g.Words)*math.Log2(float64(len(wordList))) + float64(g.Digits)*math.Log2(10)
+}
+
+// New generates a new alias.
+func (g *WordsGen) New() (string, error) {
+	parts := make([]string, g.Words, g.Words+1)
+
+	for i := range parts {
+		j, err := g.Source.Intn(len(wordList))
+		if err != nil {
+			return "", err
+		}
+		parts[i] = wordList[j]
+	}
+
+	if g.Digits > 0 {
+		b := make([]byte, g.Digits)
+		for i := range b {
+			j, err := g.Source.Intn(10)
+			if err != nil {
+				return "", err
+			}
+			b[i] = digitChars[j]
+		}
+		parts = append(parts, string(b))
+	}
+
+	return g.Prefix + strings.Join(parts, g.Separator), nil
+}